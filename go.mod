@@ -3,10 +3,14 @@ module github.com/markusylisiurunen/ship
 go 1.25.1
 
 require (
+	filippo.io/age v1.2.1
+	github.com/BurntSushi/toml v1.5.0
 	github.com/bramvdbogaerde/go-scp v1.5.0
 	github.com/hetznercloud/hcloud-go/v2 v2.24.0
+	github.com/sabhiram/go-gitignore v0.0.0-20210923224102-525f6e181f06
 	github.com/urfave/cli/v3 v3.4.1
 	golang.org/x/crypto v0.42.0
+	golang.org/x/term v0.35.0
 )
 
 require (
@@ -0,0 +1,353 @@
+package agent
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/markusylisiurunen/ship/internal/bundle"
+	"github.com/urfave/cli/v3"
+)
+
+const (
+	// releaseManifestEntry and releaseBlobsPrefix name the entries of the release tar archive streamed by the client.
+	releaseManifestEntry = "files.json"
+	releaseBlobsPrefix   = "blobs/"
+	// blobPruneGrace is how long an unlinked blob is kept after it was imported or reported present to a deploy.
+	blobPruneGrace = time.Hour
+)
+
+type BlobsMissingAction struct {
+	args appArgs
+}
+
+func NewBlobsMissingAction() *BlobsMissingAction {
+	return &BlobsMissingAction{}
+}
+
+// Action reads blob keys from stdin, one per line, and prints the ones the app does not have yet. The blobs it reports
+// as present are claimed, so that pruning leaves them alone until the deploy has linked them.
+func (a *BlobsMissingAction) Action(_ context.Context, cmd *cli.Command) error {
+	a.args = appArgs{}
+	a.args.parse(cmd)
+	if err := a.args.validate(); err != nil {
+		return err
+	}
+
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		key := strings.TrimSpace(scanner.Text())
+		if key == "" {
+			continue
+		}
+		if !bundle.ValidBlobKey(key) {
+			return fmt.Errorf("invalid blob key %q", key)
+		}
+		present, err := claimBlob(blobsDir(a.args.AppName), key)
+		if err != nil {
+			return err
+		}
+		if !present {
+			fmt.Println(key)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("read blob keys: %w", err)
+	}
+	return nil
+}
+
+// claimBlob reports whether an intact blob is in the blob store and, if it is, touches it so that pruneBlobDir keeps it
+// for blobPruneGrace. Releases share the blob's inode, so this also moves the modification time of their copies, which
+// is harmless as releases never carry the modification times of the project's files. A blob that no longer matches its
+// key is removed from the store and reported missing, so that the deploy uploads it again.
+func claimBlob(dir, key string) (bool, error) {
+	path := filepath.Join(dir, key)
+	intact, err := verifyBlob(path, key)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	if !intact {
+		fmt.Fprintf(os.Stderr, "Blob %s was changed in place, replacing it\n", key)
+		if err := removeFile(path); err != nil {
+			return false, err
+		}
+		return false, nil
+	}
+	now := time.Now()
+	if err := os.Chtimes(path, now, now); err != nil {
+		return false, fmt.Errorf("claim blob %s: %w", key, err)
+	}
+	return true, nil
+}
+
+// verifyBlob reports whether a blob still has the content hash and read-only permissions of its key. Every release
+// linking the blob shares its inode, so a write to a release file would otherwise end up in later releases too.
+func verifyBlob(path, key string) (bool, error) {
+	hash, perm, err := parseBlobKey(key)
+	if err != nil {
+		return false, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return false, err
+		}
+		return false, fmt.Errorf("open blob %s: %w", key, err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return false, fmt.Errorf("stat blob %s: %w", key, err)
+	}
+	if info.Mode().Perm() != perm {
+		return false, nil
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return false, fmt.Errorf("read blob %s: %w", key, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)) == hash, nil
+}
+
+// parseBlobKey returns the content hash of a blob key and the permissions of the blob, which are those of the key
+// without any write bits.
+func parseBlobKey(key string) (string, fs.FileMode, error) {
+	hash, permStr, _ := strings.Cut(key, "-")
+	perm, err := strconv.ParseUint(permStr, 8, 32)
+	if err != nil {
+		return "", 0, fmt.Errorf("parse permissions of blob %s: %w", key, err)
+	}
+	return hash, fs.FileMode(perm).Perm() &^ 0o222, nil
+}
+
+// blobsDir holds the content-addressed files of an app that its releases hardlink.
+func blobsDir(appName string) string {
+	return filepath.Join(appDir(appName), "blobs")
+}
+
+// receiveRelease reads the gzip-compressed tar archive of a new release: the release manifest followed by the blobs
+// the app does not have yet. The blobs are imported into the app's blob store, and nothing is written anywhere else.
+func receiveRelease(appName string, r io.Reader) (*bundle.Manifest, int, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, 0, fmt.Errorf("read release archive: %w", err)
+	}
+	defer gz.Close()
+	if err := os.MkdirAll(blobsDir(appName), 0o755); err != nil {
+		return nil, 0, fmt.Errorf("create blob store: %w", err)
+	}
+
+	var (
+		m        *bundle.Manifest
+		imported int
+		tr       = tar.NewReader(gz)
+	)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, 0, fmt.Errorf("read release archive: %w", err)
+		}
+		// Only regular files with known names are accepted, so the archive cannot place links or write outside the
+		// blob store
+		if header.Typeflag != tar.TypeReg {
+			return nil, 0, fmt.Errorf("release archive entry %q is not a regular file", header.Name)
+		}
+		switch {
+		case header.Name == releaseManifestEntry && m == nil:
+			m = &bundle.Manifest{}
+			if err := json.NewDecoder(tr).Decode(m); err != nil {
+				return nil, 0, fmt.Errorf("parse release manifest: %w", err)
+			}
+			if err := m.Validate(); err != nil {
+				return nil, 0, fmt.Errorf("invalid release manifest: %w", err)
+			}
+		case strings.HasPrefix(header.Name, releaseBlobsPrefix):
+			if err := importBlob(appName, strings.TrimPrefix(header.Name, releaseBlobsPrefix), tr); err != nil {
+				return nil, 0, err
+			}
+			imported++
+		default:
+			return nil, 0, fmt.Errorf("unexpected release archive entry %q", header.Name)
+		}
+	}
+	if m == nil {
+		return nil, 0, fmt.Errorf("release archive has no %s", releaseManifestEntry)
+	}
+	return m, imported, nil
+}
+
+// assembleRelease builds a new release directory from its manifest. Files are hardlinked from the app's blob store, so
+// unchanged files share their storage with earlier releases. The blobs, and so the files of every release, are
+// read-only: writing to a release file would change the blob under its key and every later release using it, which
+// claimBlob catches by verifying blobs before reusing them.
+func assembleRelease(appName, version string, m *bundle.Manifest) (err error) {
+	dir := releaseDir(appName, version)
+	if err := os.Mkdir(dir, 0o755); errors.Is(err, os.ErrExist) {
+		return fmt.Errorf("release %s of app %q already exists", version, appName)
+	} else if err != nil {
+		return fmt.Errorf("create release directory %s: %w", dir, err)
+	}
+	defer func() {
+		if err != nil {
+			os.RemoveAll(dir)
+		}
+	}()
+
+	// Directories are created writable and get their own permissions once everything inside them exists
+	var linked int
+	for _, f := range m.Files {
+		dst, err := releasePath(dir, f.Path)
+		if err != nil {
+			return err
+		}
+		switch f.Type {
+		case bundle.TypeDir:
+			if err := os.Mkdir(dst, 0o755); err != nil {
+				return fmt.Errorf("create directory %s: %w", f.Path, err)
+			}
+		case bundle.TypeSymlink:
+			if err := os.Symlink(filepath.FromSlash(f.Target), dst); err != nil {
+				return fmt.Errorf("create symlink %s: %w", f.Path, err)
+			}
+		case bundle.TypeFile:
+			if err := os.Link(filepath.Join(blobsDir(appName), f.BlobKey()), dst); err != nil {
+				return fmt.Errorf("link %s: %w", f.Path, err)
+			}
+			linked++
+		}
+	}
+	for _, f := range slices.Backward(m.Files) {
+		if f.Type != bundle.TypeDir {
+			continue
+		}
+		if err := os.Chmod(filepath.Join(dir, filepath.FromSlash(f.Path)), f.Mode.Perm()|0o700); err != nil {
+			return fmt.Errorf("set permissions on %s: %w", f.Path, err)
+		}
+	}
+
+	fmt.Printf("Assembled release %s from %d file(s)\n", version, linked)
+	return nil
+}
+
+// releasePath returns where a manifest entry goes in a release directory. Every directory on the way must have been
+// created earlier from the manifest and none of them may be a symlink, so that no entry is written outside the release
+// directory.
+func releasePath(dir, relPath string) (string, error) {
+	parts := strings.Split(relPath, "/")
+	current := dir
+	for _, part := range parts[:len(parts)-1] {
+		current = filepath.Join(current, part)
+		info, err := os.Lstat(current)
+		if err != nil {
+			return "", fmt.Errorf("release entry %s has no parent directory: %w", relPath, err)
+		}
+		if !info.IsDir() {
+			return "", fmt.Errorf("release entry %s is not inside a directory", relPath)
+		}
+	}
+	return filepath.Join(current, parts[len(parts)-1]), nil
+}
+
+// importBlob stores the content read from r in the app's blob store under the given key, verifying it against the
+// key's hash.
+func importBlob(appName, key string, r io.Reader) error {
+	if !bundle.ValidBlobKey(key) {
+		return fmt.Errorf("invalid blob key %q", key)
+	}
+	hash, perm, err := parseBlobKey(key)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(blobsDir(appName), ".incoming-*")
+	if err != nil {
+		return fmt.Errorf("create blob %s: %w", key, err)
+	}
+	defer os.Remove(tmp.Name())
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(tmp, h), r); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write blob %s: %w", key, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("write blob %s: %w", key, err)
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); got != hash {
+		return fmt.Errorf("blob %s has content hash %s", key, got)
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return fmt.Errorf("set permissions on blob %s: %w", key, err)
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(blobsDir(appName), key)); err != nil {
+		return fmt.Errorf("store blob %s: %w", key, err)
+	}
+	return nil
+}
+
+// pruneBlobs removes the blobs no release links to anymore.
+func pruneBlobs(appName string) error {
+	pruned, err := pruneBlobDir(blobsDir(appName), time.Now())
+	if err != nil {
+		return err
+	}
+	if pruned > 0 {
+		fmt.Printf("Removed %d unused blob(s) of app %q\n", pruned, appName)
+	}
+	return nil
+}
+
+// pruneBlobDir removes the blobs in a blob store that no release links to and that were not imported or claimed
+// within blobPruneGrace of now, so that a deploy in progress can still link the blobs it was told are present.
+func pruneBlobDir(dir string, now time.Time) (int, error) {
+	entries, err := listDirEntries(dir)
+	if err != nil {
+		return 0, err
+	}
+	pruned := 0
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			return pruned, fmt.Errorf("stat blob %s: %w", entry.Name(), err)
+		}
+		if nlink, ok := linkCount(info); !ok || nlink > 1 {
+			continue
+		}
+		if now.Sub(info.ModTime()) < blobPruneGrace {
+			continue
+		}
+		if err := removeFile(filepath.Join(dir, entry.Name())); err != nil {
+			return pruned, err
+		}
+		pruned++
+	}
+	return pruned, nil
+}
+
+// linkCount returns the number of hardlinks to a file.
+func linkCount(info fs.FileInfo) (uint64, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, false
+	}
+	return uint64(stat.Nlink), true
+}
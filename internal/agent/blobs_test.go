@@ -0,0 +1,128 @@
+package agent
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const testBlobKey = "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824-644"
+
+// writeStaleBlob stores an unlinked blob that was last imported or claimed long before the grace period.
+func writeStaleBlob(t *testing.T, dir string) {
+	t.Helper()
+	path := filepath.Join(dir, testBlobKey)
+	if err := os.WriteFile(path, []byte("hello"), 0o444); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-2 * blobPruneGrace)
+	if err := os.Chtimes(path, old, old); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPruneBlobDirKeepsClaimedBlobs(t *testing.T) {
+	// A deploy asks which blobs are missing, a prune runs, and then the deploy links the blob it was told is present
+	dir := t.TempDir()
+	writeStaleBlob(t, dir)
+	present, err := claimBlob(dir, testBlobKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !present {
+		t.Fatal("claimBlob reported a stored blob as missing")
+	}
+	pruned, err := pruneBlobDir(dir, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pruned != 0 {
+		t.Fatalf("pruneBlobDir removed %d claimed blob(s)", pruned)
+	}
+	release := t.TempDir()
+	if err := os.Link(filepath.Join(dir, testBlobKey), filepath.Join(release, "index.html")); err != nil {
+		t.Fatalf("link claimed blob: %v", err)
+	}
+}
+
+func TestPruneBlobDir(t *testing.T) {
+	tests := []struct {
+		name    string
+		linked  bool
+		claimed bool
+		pruned  int
+	}{
+		{name: "stale and unlinked", pruned: 1},
+		{name: "stale and linked", linked: true, pruned: 0},
+		{name: "claimed and unlinked", claimed: true, pruned: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeStaleBlob(t, dir)
+			if tt.linked {
+				if err := os.Link(filepath.Join(dir, testBlobKey), filepath.Join(t.TempDir(), "file")); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.claimed {
+				if _, err := claimBlob(dir, testBlobKey); err != nil {
+					t.Fatal(err)
+				}
+			}
+			pruned, err := pruneBlobDir(dir, time.Now())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if pruned != tt.pruned {
+				t.Fatalf("pruneBlobDir pruned %d blob(s), want %d", pruned, tt.pruned)
+			}
+		})
+	}
+}
+
+func TestClaimBlobMissing(t *testing.T) {
+	present, err := claimBlob(t.TempDir(), testBlobKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if present {
+		t.Fatal("claimBlob reported a missing blob as present")
+	}
+}
+
+func TestClaimBlobVerifies(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		perm    os.FileMode
+		present bool
+	}{
+		{name: "intact", content: "hello", perm: 0o444, present: true},
+		{name: "written in place", content: "HELLO", perm: 0o444, present: false},
+		{name: "made writable", content: "hello", perm: 0o644, present: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, testBlobKey)
+			if err := os.WriteFile(path, []byte(tt.content), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Chmod(path, tt.perm); err != nil {
+				t.Fatal(err)
+			}
+			present, err := claimBlob(dir, testBlobKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if present != tt.present {
+				t.Fatalf("claimBlob() = %t, want %t", present, tt.present)
+			}
+			if _, err := os.Stat(path); tt.present != (err == nil) {
+				t.Errorf("blob kept = %t, want %t", err == nil, tt.present)
+			}
+		})
+	}
+}
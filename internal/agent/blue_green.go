@@ -0,0 +1,163 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/markusylisiurunen/ship/internal/manifest"
+)
+
+const (
+	slotBlue  = "blue"
+	slotGreen = "green"
+)
+
+// composeProject returns the Docker Compose project name of an app's blue-green slot.
+func composeProject(appName, slot string) string {
+	return strings.ToLower(appName + "-" + slot)
+}
+
+// readSlot returns the blue-green slot serving the app, or an empty string if the app is not deployed blue-green.
+func readSlot(appName string) (string, error) {
+	data, err := os.ReadFile(filepath.Join(appDir(appName), "slot"))
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	} else if err != nil {
+		return "", fmt.Errorf("read blue-green slot of app %s: %w", appName, err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// writeSlot records the blue-green slot serving the app.
+func writeSlot(appName, slot string) error {
+	path := filepath.Join(appDir(appName), "slot")
+	if err := os.WriteFile(path+".tmp", []byte(slot+"\n"), 0o644); err != nil {
+		return fmt.Errorf("write blue-green slot of app %s: %w", appName, err)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return fmt.Errorf("write blue-green slot of app %s: %w", appName, err)
+	}
+	return nil
+}
+
+// activateBlueGreen starts a release under the Docker Compose project of the idle slot next to the running release,
+// waits for it to become healthy, switches Caddy over to it, and only then stops the previously serving project. If
+// the release never becomes healthy, it is stopped and the previous release keeps serving.
+func activateBlueGreen(ctx context.Context, appName, version string, check manifest.HealthCheck) error {
+	dir := releaseDir(appName, version)
+	if err := checkFileExists(filepath.Join(dir, ".ship", "compose.yml")); err != nil {
+		return fmt.Errorf("blue-green deploys require a .ship/compose.yml: %w", err)
+	}
+
+	previous, err := currentRelease(appName)
+	if err != nil {
+		return err
+	}
+	oldSlot, err := readSlot(appName)
+	if err != nil {
+		return err
+	}
+	newSlot := slotBlue
+	if oldSlot == slotBlue {
+		newSlot = slotGreen
+	}
+	project := composeProject(appName, newSlot)
+
+	// The secrets env file is shared by every release, so one the serving release reads is kept until it is stopped
+	m, err := loadReleaseManifest(appName, version)
+	if err != nil {
+		return err
+	}
+	previousEnvFile := false
+	if previous != "" {
+		pm, err := loadReleaseManifest(appName, previous)
+		if err != nil {
+			return err
+		}
+		previousEnvFile = pm.Secrets.EnvFile
+	}
+
+	// Start the release next to the one currently serving
+	if err := linkReleaseShared(ctx, appName, version, previousEnvFile); err != nil {
+		return err
+	}
+	fmt.Printf("Starting release %s as Docker Compose project %s\n", version, project)
+	if err := composeUp(ctx, appName, version, project); err != nil {
+		if downErr := composeDown(ctx, project); downErr != nil {
+			fmt.Printf("Failed to stop release %s: %v\n", version, downErr)
+		}
+		return err
+	}
+	if err := waitForHealthy(ctx, version, manifest.HealthCheckCompose, check, func(ctx context.Context) error {
+		return probeCompose(ctx, dir, project)
+	}); err != nil {
+		fmt.Printf("Release %s is unhealthy, stopping it and keeping the previous release\n", version)
+		if downErr := composeDown(ctx, project); downErr != nil {
+			fmt.Printf("Failed to stop release %s: %v\n", version, downErr)
+		}
+		return err
+	}
+
+	// Switch traffic over to the new release
+	fmt.Printf("Switching Caddy over to release %s\n", version)
+	if ok, err := installCaddyfile(ctx, appName, version, project); err != nil {
+		return err
+	} else if !ok {
+		fmt.Printf("No .ship/Caddyfile found, skipping Caddy steps\n")
+	}
+	if check.Type == manifest.HealthCheckHTTP {
+		if err := waitForReleaseHealthy(ctx, appName, version, check); err != nil {
+			fmt.Printf("Release %s is unhealthy behind Caddy, switching back\n", version)
+			if previous != "" {
+				oldProject := ""
+				if oldSlot != "" {
+					oldProject = composeProject(appName, oldSlot)
+				}
+				if _, restoreErr := installCaddyfile(ctx, appName, previous, oldProject); restoreErr != nil {
+					return fmt.Errorf("%w; switching Caddy back to release %s also failed: %w", err, previous, restoreErr)
+				}
+			}
+			if downErr := composeDown(ctx, project); downErr != nil {
+				fmt.Printf("Failed to stop release %s: %v\n", version, downErr)
+			}
+			return err
+		}
+	}
+	if err := symlink(dir, filepath.Join(appDir(appName), "current")); err != nil {
+		return err
+	}
+	if err := writeSlot(appName, newSlot); err != nil {
+		return err
+	}
+
+	// Stop the release that was serving before
+	switch {
+	case oldSlot != "":
+		fmt.Printf("Stopping Docker Compose project %s\n", composeProject(appName, oldSlot))
+		if err := composeDown(ctx, composeProject(appName, oldSlot)); err != nil {
+			return err
+		}
+	case previous != "" && previous != version:
+		// The previous release was deployed in place under the default project name of its release directory
+		previousDir := releaseDir(appName, previous)
+		if checkFileExists(filepath.Join(previousDir, ".ship", "compose.yml")) == nil {
+			fmt.Printf("Stopping release %s\n", previous)
+			if err := execRunInDir(ctx, previousDir,
+				"docker", "compose", "-f", "./.ship/compose.yml", "down", "--remove-orphans",
+			); err != nil {
+				return fmt.Errorf("stop release %s: %w", previous, err)
+			}
+		}
+	}
+	if previousEnvFile && !m.Secrets.EnvFile {
+		if err := materializeSecrets(ctx, appName, false); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -2,11 +2,12 @@ package agent
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
-	"regexp"
+	"slices"
+	"time"
 
 	"github.com/urfave/cli/v3"
 )
@@ -18,41 +19,50 @@ const (
 )
 
 type deployArgs struct {
-	AppName     string
-	AppVersion  string
-	VolumeNames []string
+	AppName      string
+	AppVersion   string
+	VolumeNames  []string
+	KeepReleases int
+	LockHolder   string
 }
 
 func (a *deployArgs) parse(cmd *cli.Command) {
 	a.AppName = cmd.String("app-name")
 	a.AppVersion = cmd.String("app-version")
 	a.VolumeNames = cmd.StringSlice("volume-name")
+	a.KeepReleases = int(cmd.Int("keep-releases"))
+	a.LockHolder = cmd.String("lock-holder")
 }
 
 func (a deployArgs) validate() error {
-	alphaNumRegex := regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
 	if a.AppName == "" {
 		return fmt.Errorf("app name is required")
 	}
-	if !alphaNumRegex.MatchString(a.AppName) {
+	if !alphaNumRegexp.MatchString(a.AppName) {
 		return fmt.Errorf("app name can only contain letters, numbers, dashes, and underscores")
 	}
 	if a.AppVersion == "" {
 		return fmt.Errorf("app version is required")
 	}
-	if !alphaNumRegex.MatchString(a.AppVersion) {
+	if !alphaNumRegexp.MatchString(a.AppVersion) {
 		return fmt.Errorf("app version can only contain letters, numbers, dashes, and underscores")
 	}
+	if slices.Contains(reservedAppEntries, a.AppVersion) {
+		return fmt.Errorf("app version %q is reserved", a.AppVersion)
+	}
 	if len(a.VolumeNames) > 0 {
 		for _, v := range a.VolumeNames {
 			if v == "" {
 				return fmt.Errorf("volume name cannot be empty")
 			}
-			if !alphaNumRegex.MatchString(v) {
+			if !alphaNumRegexp.MatchString(v) {
 				return fmt.Errorf("volume name %q can only contain letters, numbers, dashes, and underscores", v)
 			}
 		}
 	}
+	if a.KeepReleases < 0 {
+		return fmt.Errorf("number of releases to keep cannot be negative")
+	}
 	return nil
 }
 
@@ -71,123 +81,102 @@ func (a *DeployAction) Action(ctx context.Context, cmd *cli.Command) error {
 		return err
 	}
 
-	archivePath := filepath.Join("/home/deploy/apps", a.args.AppName, a.args.AppVersion, "archive.zip")
-	if err := checkFileExists(archivePath); err != nil {
+	lock, err := acquireAppLock(a.args.AppName, newLockHolder("deploy", a.args.LockHolder, a.args.AppVersion))
+	if err != nil {
 		return err
 	}
+	defer lock.release()
 
-	if entries, err := listDirEntries(filepath.Dir(archivePath)); err != nil {
+	files, imported, err := receiveRelease(a.args.AppName, os.Stdin)
+	if err != nil {
 		return err
-	} else if len(entries) > 1 {
-		return fmt.Errorf("archive directory %q is not empty", filepath.Dir(archivePath))
 	}
-
-	if err := a.execRun(ctx, "unzip", "-oq", archivePath, "-d", filepath.Dir(archivePath)); err != nil {
+	fmt.Printf("Received release %s with %d new blob(s)\n", a.args.AppVersion, imported)
+	if err := assembleRelease(a.args.AppName, a.args.AppVersion, files); err != nil {
 		return err
 	}
-	if err := removeFile(archivePath); err != nil {
-		fmt.Printf("Failed to remove the archive.zip file: %v\n", err)
-	}
 
 	for _, dir := range []struct {
 		path string
 		perm os.FileMode
 	}{
-		{path: filepath.Join("/home/deploy/apps", a.args.AppName, "volumes"), perm: appVolumesDirPerm},
-		{path: filepath.Join("/home/deploy/apps", a.args.AppName, "secrets"), perm: appSecretsDirPerm},
-		{path: filepath.Join("/home/deploy/apps", a.args.AppName, a.args.AppVersion, ".ship"), perm: appShipDirPerm},
+		{path: filepath.Join(appDir(a.args.AppName), "volumes"), perm: appVolumesDirPerm},
+		{path: filepath.Join(releaseDir(a.args.AppName, a.args.AppVersion), ".ship"), perm: appShipDirPerm},
 	} {
 		if err := ensureDirExists(dir.path, dir.perm); err != nil {
 			return err
 		}
 	}
+	if err := recordDeploy(a.args.AppName, a.args.AppVersion, time.Now()); err != nil {
+		return err
+	}
+	// The agent writes secrets as the deploy user
+	if err := ensureDirExistsAndOwnedBy(secretsDir(a.args.AppName), appSecretsDirPerm, "deploy"); err != nil {
+		return err
+	}
 
 	if len(a.args.VolumeNames) > 0 {
 		for _, v := range a.args.VolumeNames {
-			volumePath := filepath.Join("/home/deploy/apps", a.args.AppName, "volumes", v)
+			volumePath := filepath.Join(appDir(a.args.AppName), "volumes", v)
 			if err := ensureDirExistsAndOwnedBy(volumePath, appVolumesDirPerm, "root"); err != nil {
 				return err
 			}
 		}
 	}
 
-	for _, l := range []struct {
-		src string
-		dst string
-	}{
-		{
-			src: filepath.Join("/home/deploy/apps", a.args.AppName, "volumes"),
-			dst: filepath.Join("/home/deploy/apps", a.args.AppName, a.args.AppVersion, ".ship", "volumes"),
-		},
-		{
-			src: filepath.Join("/home/deploy/apps", a.args.AppName, "secrets"),
-			dst: filepath.Join("/home/deploy/apps", a.args.AppName, a.args.AppVersion, ".ship", "secrets"),
-		},
-		{
-			src: filepath.Join("/home/deploy/apps", a.args.AppName, a.args.AppVersion),
-			dst: filepath.Join("/home/deploy/apps", a.args.AppName, "current"),
-		},
-	} {
-		if err := symlink(l.src, l.dst); err != nil {
-			return err
-		}
+	previous, err := currentRelease(a.args.AppName)
+	if err != nil {
+		return err
 	}
-
-	if err := checkFileExists(
-		filepath.Join("/home/deploy/apps", a.args.AppName, a.args.AppVersion, ".ship", "compose.yml"),
-	); err == nil {
-		for _, c := range [][]string{
-			{"docker", "compose", "-f", "./.ship/compose.yml", "pull"},
-			{"docker", "compose", "-f", "./.ship/compose.yml", "build", "--pull", "--build-arg", "VERSION=" + a.args.AppVersion},
-			{"docker", "compose", "-f", "./.ship/compose.yml", "up", "-d", "--remove-orphans", "--no-build"},
-		} {
-			if err := a.execRunInDir(
-				ctx,
-				filepath.Join("/home/deploy/apps", a.args.AppName, a.args.AppVersion),
-				c[0], c[1:]...,
-			); err != nil {
-				return err
+	m, err := loadReleaseManifest(a.args.AppName, a.args.AppVersion)
+	if err != nil {
+		return err
+	}
+	if m.Deploy.BlueGreen() {
+		// A blue-green release only receives traffic once healthy, so a failure leaves the previous release serving
+		if err := activateBlueGreen(ctx, a.args.AppName, a.args.AppVersion, m.HealthCheck); err != nil {
+			var unhealthy *unhealthyError
+			if errors.As(err, &unhealthy) {
+				a.removeFailedRelease(ctx)
 			}
+			return fmt.Errorf("deploy failed: %w", err)
 		}
 	} else {
-		fmt.Printf("No .ship/compose.yml found, skipping Docker Compose steps\n")
-	}
-
-	if err := checkFileExists(
-		filepath.Join("/home/deploy/apps", a.args.AppName, a.args.AppVersion, ".ship", "Caddyfile"),
-	); err == nil {
-		for _, c := range [][]string{
-			{"sudo", "cp", "./.ship/Caddyfile", "/root/.caddy/sites-enabled/" + a.args.AppName},
-			{"sudo", "chown", "root:root", "/root/.caddy/sites-enabled/" + a.args.AppName},
-			{"sudo", "chmod", "644", "/root/.caddy/sites-enabled/" + a.args.AppName},
-			{"sudo", "bash", "-c", "cd /root/.caddy && docker compose exec caddy caddy reload --config /etc/caddy/Caddyfile"},
-		} {
-			if err := a.execRunInDir(
-				ctx,
-				filepath.Join("/home/deploy/apps", a.args.AppName, a.args.AppVersion),
-				c[0], c[1:]...,
-			); err != nil {
-				return err
-			}
+		if err := activateInPlace(ctx, a.args.AppName, a.args.AppVersion); err != nil {
+			return err
+		}
+		if err := waitForReleaseHealthy(ctx, a.args.AppName, a.args.AppVersion, m.HealthCheck); err != nil {
+			return a.rollBackUnhealthy(ctx, previous, err)
+		}
+	}
+
+	if a.args.KeepReleases > 0 {
+		if err := pruneReleases(ctx, a.args.AppName, a.args.KeepReleases); err != nil {
+			fmt.Printf("Failed to prune old releases: %v\n", err)
 		}
-	} else {
-		fmt.Printf("No .ship/Caddyfile found, skipping Caddy steps\n")
 	}
 
 	return nil
 }
 
-func (a *DeployAction) execRun(ctx context.Context, name string, args ...string) error {
-	cmd := exec.CommandContext(ctx, name, args...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	return cmd.Run()
+// rollBackUnhealthy reactivates the previously current release after the deployed release failed its health check,
+// and returns the health check failure. The failed release is removed so that it is never picked as a rollback target
+// and the same version can be deployed again once fixed.
+func (a *DeployAction) rollBackUnhealthy(ctx context.Context, previous string, healthErr error) error {
+	if previous == "" || previous == a.args.AppVersion {
+		return fmt.Errorf("deploy failed, no previous release to roll back to: %w", healthErr)
+	}
+	fmt.Printf("Release %s is unhealthy, rolling back to %s\n", a.args.AppVersion, previous)
+	if err := activateRelease(ctx, a.args.AppName, previous); err != nil {
+		return fmt.Errorf("deploy failed: %w; rolling back to %s also failed: %w", healthErr, previous, err)
+	}
+	a.removeFailedRelease(ctx)
+	return fmt.Errorf("deploy failed, rolled back to %s: %w", previous, healthErr)
 }
 
-func (a *DeployAction) execRunInDir(ctx context.Context, dir string, name string, args ...string) error {
-	cmd := exec.CommandContext(ctx, name, args...)
-	cmd.Dir = dir
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	return cmd.Run()
+// removeFailedRelease removes the release that failed its health check.
+func (a *DeployAction) removeFailedRelease(ctx context.Context) {
+	if err := execRun(ctx, "sudo", "rm", "-rf", releaseDir(a.args.AppName, a.args.AppVersion)); err != nil {
+		fmt.Printf("Failed to remove the failed release %s: %v\n", a.args.AppVersion, err)
+	}
 }
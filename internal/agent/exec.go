@@ -0,0 +1,82 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+
+	"github.com/urfave/cli/v3"
+)
+
+type execArgs struct {
+	AppName string
+	Service string
+	NoTTY   bool
+	Command []string
+}
+
+func (a *execArgs) parse(cmd *cli.Command) {
+	a.AppName = cmd.String("app-name")
+	a.Service = cmd.String("service")
+	a.NoTTY = cmd.Bool("no-tty")
+	a.Command = cmd.Args().Slice()
+}
+
+func (a execArgs) validate() error {
+	if a.AppName == "" {
+		return fmt.Errorf("app name is required")
+	}
+	if !alphaNumRegexp.MatchString(a.AppName) {
+		return fmt.Errorf("app name can only contain letters, numbers, dashes, and underscores")
+	}
+	if !composeServiceRegexp.MatchString(a.Service) {
+		return fmt.Errorf("service name %q is invalid", a.Service)
+	}
+	if len(a.Command) == 0 {
+		return fmt.Errorf("command is required")
+	}
+	return nil
+}
+
+type ExecAction struct {
+	args execArgs
+}
+
+func NewExecAction() *ExecAction {
+	return &ExecAction{}
+}
+
+// Action runs a command in a running container of the app's current release. The agent replaces itself with `docker
+// compose exec`, so the terminal, signals, and exit status of the SSH session belong to the command.
+func (a *ExecAction) Action(_ context.Context, cmd *cli.Command) error {
+	a.args = execArgs{}
+	a.args.parse(cmd)
+	if err := a.args.validate(); err != nil {
+		return err
+	}
+
+	dir, args, err := currentCompose(a.args.AppName)
+	if err != nil {
+		return err
+	}
+	args = append(args, "exec")
+	if a.args.NoTTY {
+		args = append(args, "-T")
+	}
+	args = append(args, a.args.Service)
+	args = append(args, a.args.Command...)
+
+	docker, err := exec.LookPath("docker")
+	if err != nil {
+		return fmt.Errorf("find docker: %w", err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		return fmt.Errorf("change to release directory %s: %w", dir, err)
+	}
+	if err := syscall.Exec(docker, append([]string{"docker"}, args...), os.Environ()); err != nil {
+		return fmt.Errorf("run docker compose exec: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,208 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/markusylisiurunen/ship/internal/manifest"
+)
+
+const (
+	defaultHealthCheckTimeout  = 60 * time.Second
+	defaultHealthCheckInterval = 2 * time.Second
+)
+
+// loadReleaseManifest reads the manifest shipped in a release's .ship directory, returning an empty manifest if the
+// release has none.
+func loadReleaseManifest(appName, version string) (*manifest.Manifest, error) {
+	m, err := manifest.LoadIfExists(filepath.Join(releaseDir(appName, version), manifest.DefaultPath))
+	if err != nil {
+		return nil, err
+	}
+	if m == nil {
+		return &manifest.Manifest{}, nil
+	}
+	return m, nil
+}
+
+// unhealthyError reports a release that did not pass its health check in time.
+type unhealthyError struct {
+	version string
+	timeout time.Duration
+	err     error
+}
+
+func (e *unhealthyError) Error() string {
+	return fmt.Sprintf("release %s did not become healthy within %s: %v", e.version, e.timeout, e.err)
+}
+
+func (e *unhealthyError) Unwrap() error {
+	return e.err
+}
+
+// waitForReleaseHealthy polls the health check of a release until it passes or times out.
+func waitForReleaseHealthy(ctx context.Context, appName, version string, check manifest.HealthCheck) error {
+	var probe func(ctx context.Context) error
+	switch check.Type {
+	case manifest.HealthCheckHTTP:
+		probe = func(ctx context.Context) error { return probeHTTP(ctx, check.URL) }
+	case manifest.HealthCheckCompose:
+		probe = func(ctx context.Context) error { return probeCompose(ctx, releaseDir(appName, version), "") }
+	default:
+		return nil
+	}
+	return waitForHealthy(ctx, version, check.Type, check, probe)
+}
+
+// waitForHealthy calls the probe at the check's interval until it succeeds or the check's timeout is reached.
+func waitForHealthy(
+	ctx context.Context,
+	version, kind string,
+	check manifest.HealthCheck,
+	probe func(ctx context.Context) error,
+) error {
+	timeout, interval := check.Timeout, check.Interval
+	if timeout == 0 {
+		timeout = defaultHealthCheckTimeout
+	}
+	if interval == 0 {
+		interval = defaultHealthCheckInterval
+	}
+
+	fmt.Printf("Waiting up to %s for release %s to become healthy (%s check)...\n", timeout, version, kind)
+	deadline := time.Now().Add(timeout)
+	for {
+		probeErr := probe(ctx)
+		if probeErr == nil {
+			fmt.Printf("Release %s is healthy\n", version)
+			return nil
+		}
+		if time.Now().Add(interval).After(deadline) {
+			return &unhealthyError{version: version, timeout: timeout, err: probeErr}
+		}
+		fmt.Printf("Release %s not healthy yet: %v\n", version, probeErr)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
+
+// probeHTTP requests the URL through the Caddy instance on this machine and expects a non-error status.
+func probeHTTP(ctx context.Context, rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("parse health check url: %w", err)
+	}
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+	client := &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, net.JoinHostPort("127.0.0.1", port))
+			},
+		},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return fmt.Errorf("create health check request: %w", err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("GET %s returned %s", rawURL, resp.Status)
+	}
+	return nil
+}
+
+type composeContainer struct {
+	Name    string `json:"Name"`
+	Service string `json:"Service"`
+	State   string `json:"State"`
+	Health  string `json:"Health"`
+}
+
+// probeCompose expects every container of the release's Docker Compose stack to be running, and healthy if it
+// defines a healthcheck. An empty project name leaves the project name up to Docker Compose.
+func probeCompose(ctx context.Context, dir, project string) error {
+	containers, err := composePs(ctx, dir, project)
+	if err != nil {
+		return err
+	}
+	if len(containers) == 0 {
+		return fmt.Errorf("no containers found")
+	}
+	var problems []string
+	for _, c := range containers {
+		switch {
+		case c.State != "running":
+			problems = append(problems, fmt.Sprintf("%s is %s", c.Service, c.State))
+		case c.Health != "" && c.Health != "healthy":
+			problems = append(problems, fmt.Sprintf("%s is %s", c.Service, c.Health))
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%s", strings.Join(problems, ", "))
+	}
+	return nil
+}
+
+// composePs lists the containers of the Docker Compose stack in a release directory.
+func composePs(ctx context.Context, dir, project string) ([]composeContainer, error) {
+	args := []string{"compose", "-f", "./.ship/compose.yml"}
+	if project != "" {
+		args = append(args, "-p", project)
+	}
+	args = append(args, "ps", "--all", "--format", "json")
+	cmd := exec.CommandContext(ctx, "docker", args...)
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("docker compose ps: %w", err)
+	}
+	return parseComposePs(out)
+}
+
+// parseComposePs parses the output of `docker compose ps --format json`.
+func parseComposePs(out []byte) ([]composeContainer, error) {
+	// Older Compose versions print a JSON array, newer ones a JSON object per line
+	out = bytes.TrimSpace(out)
+	var containers []composeContainer
+	if bytes.HasPrefix(out, []byte("[")) {
+		if err := json.Unmarshal(out, &containers); err != nil {
+			return nil, fmt.Errorf("parse docker compose ps output: %w", err)
+		}
+		return containers, nil
+	}
+	for line := range bytes.SplitSeq(out, []byte("\n")) {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		var c composeContainer
+		if err := json.Unmarshal(line, &c); err != nil {
+			return nil, fmt.Errorf("parse docker compose ps output: %w", err)
+		}
+		containers = append(containers, c)
+	}
+	return containers, nil
+}
@@ -0,0 +1,53 @@
+package agent
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseComposePs(t *testing.T) {
+	web := composeContainer{Name: "app-web-1", Service: "web", State: "running", Health: "healthy"}
+	db := composeContainer{Name: "app-db-1", Service: "db", State: "exited"}
+	tests := []struct {
+		name    string
+		out     string
+		want    []composeContainer
+		wantErr string
+	}{
+		{name: "no output", out: "", want: nil},
+		{name: "empty array", out: "[]\n", want: []composeContainer{}},
+		{
+			name: "array",
+			out: `[{"Name":"app-web-1","Service":"web","State":"running","Health":"healthy","Image":"x"},` +
+				`{"Name":"app-db-1","Service":"db","State":"exited","Health":""}]` + "\n",
+			want: []composeContainer{web, db},
+		},
+		{
+			name: "object per line",
+			out: `{"Name":"app-web-1","Service":"web","State":"running","Health":"healthy","Image":"x"}` + "\n\n" +
+				`{"Name":"app-db-1","Service":"db","State":"exited","Health":""}` + "\n",
+			want: []composeContainer{web, db},
+		},
+		{name: "single object", out: `{"Name":"app-db-1","Service":"db","State":"exited"}`, want: []composeContainer{db}},
+		{name: "invalid array", out: `[{"Name":}]`, wantErr: "parse docker compose ps output"},
+		{name: "invalid line", out: "{\"Name\":\"a\"}\nnot json\n", wantErr: "parse docker compose ps output"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseComposePs([]byte(tt.out))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("parseComposePs() error = %v, want error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseComposePs() error = %v", err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("parseComposePs() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,278 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/markusylisiurunen/ship/internal/applock"
+	"github.com/urfave/cli/v3"
+)
+
+const (
+	lockBreakTimeout = 10 * time.Second
+	// Taking an app lock is retried for a moment, as `lock status` and `lock break` briefly hold a shared lock on the
+	// lock file to see whether it is held
+	lockRetryTimeout  = time.Second
+	lockRetryInterval = 50 * time.Millisecond
+)
+
+type appArgs struct {
+	AppName string
+}
+
+func (a *appArgs) parse(cmd *cli.Command) {
+	a.AppName = cmd.String("app-name")
+}
+
+func (a appArgs) validate() error {
+	if a.AppName == "" {
+		return fmt.Errorf("app name is required")
+	}
+	if !alphaNumRegexp.MatchString(a.AppName) {
+		return fmt.Errorf("app name can only contain letters, numbers, dashes, and underscores")
+	}
+	return nil
+}
+
+type LockStatusAction struct {
+	args appArgs
+}
+
+func NewLockStatusAction() *LockStatusAction {
+	return &LockStatusAction{}
+}
+
+func (a *LockStatusAction) Action(_ context.Context, cmd *cli.Command) error {
+	a.args = appArgs{}
+	a.args.parse(cmd)
+	if err := a.args.validate(); err != nil {
+		return err
+	}
+
+	locked, err := appLocked(a.args.AppName)
+	if err != nil {
+		return err
+	}
+	s := applock.Status{Locked: locked}
+	if locked {
+		if s.Holder, err = readLockHolder(a.args.AppName); err != nil {
+			return err
+		}
+	}
+	return json.NewEncoder(os.Stdout).Encode(s)
+}
+
+type LockBreakAction struct {
+	args appArgs
+}
+
+func NewLockBreakAction() *LockBreakAction {
+	return &LockBreakAction{}
+}
+
+func (a *LockBreakAction) Action(ctx context.Context, cmd *cli.Command) error {
+	a.args = appArgs{}
+	a.args.parse(cmd)
+	if err := a.args.validate(); err != nil {
+		return err
+	}
+
+	locked, err := appLocked(a.args.AppName)
+	if err != nil {
+		return err
+	}
+	if !locked {
+		fmt.Printf("App %q is not locked\n", a.args.AppName)
+		return nil
+	}
+	holder, err := readLockHolder(a.args.AppName)
+	if err != nil {
+		fmt.Printf("Failed to read the lock holder: %v\n", err)
+	}
+
+	// Ask the holding process to stop, which releases the lock once it exits
+	if holder != nil && holder.PID > 0 {
+		fmt.Printf("Stopping %s\n", holder)
+		if err := syscall.Kill(holder.PID, syscall.SIGTERM); err != nil && !errors.Is(err, syscall.ESRCH) {
+			fmt.Printf("Failed to stop pid %d: %v\n", holder.PID, err)
+		}
+		deadline := time.Now().Add(lockBreakTimeout)
+		for time.Now().Before(deadline) {
+			if locked, err := appLocked(a.args.AppName); err != nil {
+				return err
+			} else if !locked {
+				fmt.Printf("Lock of app %q released\n", a.args.AppName)
+				return nil
+			}
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(500 * time.Millisecond):
+			}
+		}
+	}
+
+	// Removing the lock file lets new operations take a fresh lock even though the holder still has the old one
+	if err := removeFile(lockPath(a.args.AppName)); err != nil {
+		return err
+	}
+	fmt.Printf("Removed the lock file of app %q; the previous holder may still be running\n", a.args.AppName)
+	return nil
+}
+
+// appLock is the exclusive per-app lock held while an operation changes an app on the machine. The lock is an flock(2)
+// on the app's lock file, so it is released by the kernel even if the holder dies, and the file records who holds it.
+type appLock struct {
+	file *os.File
+}
+
+// lockedError is returned when an app lock is held by another operation.
+type lockedError struct {
+	appName string
+	holder  *applock.Holder
+}
+
+func (e *lockedError) Error() string {
+	if e.holder == nil {
+		return fmt.Sprintf("app %q is locked by another operation; "+
+			"if it is stale, run `ship lock break --app-name %s`", e.appName, e.appName)
+	}
+	return fmt.Sprintf("%s in progress by %s since %s (pid %d); if it is stale, run `ship lock break --app-name %s`",
+		e.holder.Operation, e.holder.User, e.holder.StartedAt.Local().Format("2006-01-02 15:04:05 MST"), e.holder.PID,
+		e.appName)
+}
+
+func lockPath(appName string) string {
+	return filepath.Join(appDir(appName), "lock")
+}
+
+// newLockHolder describes an operation run by the given user, falling back to the local user if none is given.
+func newLockHolder(operation, holder, version string) applock.Holder {
+	if holder == "" {
+		holder = applock.LocalUser()
+	}
+	return applock.Holder{
+		Operation: operation,
+		User:      holder,
+		Version:   version,
+		PID:       os.Getpid(),
+		StartedAt: time.Now().UTC(),
+	}
+}
+
+// acquireAppLock takes the exclusive lock of an app without waiting for another operation, returning a *lockedError
+// if one holds it.
+func acquireAppLock(appName string, holder applock.Holder) (*appLock, error) {
+	if err := os.MkdirAll(appDir(appName), 0o755); err != nil {
+		return nil, fmt.Errorf("create directory for app %s: %w", appName, err)
+	}
+	path := lockPath(appName)
+	deadline := time.Now().Add(lockRetryTimeout)
+	for {
+		f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+		if err != nil {
+			return nil, fmt.Errorf("open lock file %s: %w", path, err)
+		}
+		if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+			f.Close()
+			if errors.Is(err, syscall.EWOULDBLOCK) && time.Now().Before(deadline) {
+				time.Sleep(lockRetryInterval)
+				continue
+			}
+			if errors.Is(err, syscall.EWOULDBLOCK) {
+				current, _ := readLockHolder(appName)
+				return nil, &lockedError{appName: appName, holder: current}
+			}
+			return nil, fmt.Errorf("lock %s: %w", path, err)
+		}
+
+		// The lock file may have been removed by `lock break` between opening and locking it
+		if same, err := sameFile(f, path); err != nil {
+			f.Close()
+			return nil, err
+		} else if !same {
+			f.Close()
+			continue
+		}
+
+		data, err := json.Marshal(holder)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("encode lock holder: %w", err)
+		}
+		if err := f.Truncate(0); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("truncate lock file %s: %w", path, err)
+		}
+		if _, err := f.WriteAt(append(data, '\n'), 0); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("write lock file %s: %w", path, err)
+		}
+		return &appLock{file: f}, nil
+	}
+}
+
+// release clears the holder information and releases the lock.
+func (l *appLock) release() {
+	if err := l.file.Truncate(0); err != nil {
+		fmt.Printf("Failed to clear lock file %s: %v\n", l.file.Name(), err)
+	}
+	l.file.Close()
+}
+
+// readLockHolder returns the holder recorded in an app's lock file, or nil if none is recorded.
+func readLockHolder(appName string) (*applock.Holder, error) {
+	data, err := os.ReadFile(lockPath(appName))
+	if errors.Is(err, os.ErrNotExist) || len(data) == 0 {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("read lock file of app %s: %w", appName, err)
+	}
+	var holder applock.Holder
+	if err := json.Unmarshal(data, &holder); err != nil {
+		return nil, fmt.Errorf("parse lock file of app %s: %w", appName, err)
+	}
+	return &holder, nil
+}
+
+// appLocked reports whether another operation currently holds the lock of an app. It probes with a shared lock, which
+// fails while an operation holds the exclusive one and only delays operations starting meanwhile.
+func appLocked(appName string) (bool, error) {
+	f, err := os.Open(lockPath(appName))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("open lock file of app %s: %w", appName, err)
+	}
+	defer f.Close()
+	err = syscall.Flock(int(f.Fd()), syscall.LOCK_SH|syscall.LOCK_NB)
+	switch {
+	case err == nil:
+		return false, nil
+	case errors.Is(err, syscall.EWOULDBLOCK):
+		return true, nil
+	default:
+		return false, fmt.Errorf("check lock of app %s: %w", appName, err)
+	}
+}
+
+// sameFile reports whether the open file is still the file at the given path.
+func sameFile(f *os.File, path string) (bool, error) {
+	opened, err := f.Stat()
+	if err != nil {
+		return false, fmt.Errorf("stat %s: %w", f.Name(), err)
+	}
+	current, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("stat %s: %w", path, err)
+	}
+	return os.SameFile(opened, current), nil
+}
@@ -0,0 +1,96 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"regexp"
+	"syscall"
+	"time"
+
+	"github.com/urfave/cli/v3"
+)
+
+// composeServiceRegexp matches the service names Docker Compose accepts.
+var composeServiceRegexp = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]*$`)
+
+type logsArgs struct {
+	AppName  string
+	Services []string
+	Follow   bool
+	Since    string
+	Tail     string
+}
+
+func (a *logsArgs) parse(cmd *cli.Command) {
+	a.AppName = cmd.String("app-name")
+	a.Services = cmd.StringSlice("service")
+	a.Follow = cmd.Bool("follow")
+	a.Since = cmd.String("since")
+	a.Tail = cmd.String("tail")
+}
+
+func (a logsArgs) validate() error {
+	if a.AppName == "" {
+		return fmt.Errorf("app name is required")
+	}
+	if !alphaNumRegexp.MatchString(a.AppName) {
+		return fmt.Errorf("app name can only contain letters, numbers, dashes, and underscores")
+	}
+	for _, service := range a.Services {
+		if !composeServiceRegexp.MatchString(service) {
+			return fmt.Errorf("service name %q is invalid", service)
+		}
+	}
+	return nil
+}
+
+type LogsAction struct {
+	args logsArgs
+}
+
+func NewLogsAction() *LogsAction {
+	return &LogsAction{}
+}
+
+// Action streams the Docker Compose logs of the app's current release. The client stops it with a signal, which
+// counts as success.
+func (a *LogsAction) Action(ctx context.Context, cmd *cli.Command) error {
+	a.args = logsArgs{}
+	a.args.parse(cmd)
+	if err := a.args.validate(); err != nil {
+		return err
+	}
+
+	dir, args, err := currentCompose(a.args.AppName)
+	if err != nil {
+		return err
+	}
+	args = append(args, "logs")
+	if a.args.Follow {
+		args = append(args, "--follow")
+	}
+	if a.args.Since != "" {
+		args = append(args, "--since", a.args.Since)
+	}
+	if a.args.Tail != "" {
+		args = append(args, "--tail", a.args.Tail)
+	}
+	args = append(args, a.args.Services...)
+
+	// Closing the SSH session hangs up the agent, and Ctrl-C on the client arrives as SIGTERM or SIGINT
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	defer stop()
+	logs := exec.CommandContext(ctx, "docker", args...)
+	logs.Dir = dir
+	logs.Stdout = os.Stdout
+	logs.Stderr = os.Stderr
+	logs.Cancel = func() error { return logs.Process.Signal(os.Interrupt) }
+	logs.WaitDelay = 5 * time.Second
+	if err := logs.Run(); err != nil && ctx.Err() == nil {
+		return fmt.Errorf("docker compose logs: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,288 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+const appsDir = "/home/deploy/apps"
+
+// reservedAppEntries are the entries of an app directory that are not releases.
+var reservedAppEntries = []string{"blobs", "current", "lock", "secrets", "slot", "volumes"}
+
+type release struct {
+	Version    string
+	DeployedAt time.Time
+	Current    bool
+}
+
+func appDir(appName string) string {
+	return filepath.Join(appsDir, appName)
+}
+
+func releaseDir(appName, version string) string {
+	return filepath.Join(appsDir, appName, version)
+}
+
+// currentRelease returns the version the `current` symlink of an app points at, or an empty string if there is none.
+func currentRelease(appName string) (string, error) {
+	target, err := os.Readlink(filepath.Join(appDir(appName), "current"))
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	} else if err != nil {
+		return "", fmt.Errorf("read current release of %s: %w", appName, err)
+	}
+	return filepath.Base(target), nil
+}
+
+// listReleases lists the releases of an app, oldest deploy first.
+func listReleases(appName string) ([]release, error) {
+	current, err := currentRelease(appName)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := listDirEntries(appDir(appName))
+	if err != nil {
+		return nil, err
+	}
+	var releases []release
+	for _, entry := range entries {
+		if !entry.IsDir() || slices.Contains(reservedAppEntries, entry.Name()) {
+			continue
+		}
+		deployedAt, err := releaseDeployedAt(appName, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+		releases = append(releases, release{
+			Version:    entry.Name(),
+			DeployedAt: deployedAt,
+			Current:    entry.Name() == current,
+		})
+	}
+	slices.SortFunc(releases, func(a, b release) int {
+		if c := a.DeployedAt.Compare(b.DeployedAt); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Version, b.Version)
+	})
+	return releases, nil
+}
+
+// deployedAtPath is the file in a release's .ship directory that records when the release was deployed.
+func deployedAtPath(appName, version string) string {
+	return filepath.Join(releaseDir(appName, version), ".ship", "deployed_at")
+}
+
+// recordDeploy records when a release was deployed. The directory times of releases are not used for this, as
+// anything written into a release directory, such as the links to the app's shared directories, changes them.
+func recordDeploy(appName, version string, deployedAt time.Time) error {
+	data := []byte(deployedAt.UTC().Format(time.RFC3339Nano) + "\n")
+	if err := os.WriteFile(deployedAtPath(appName, version), data, 0o644); err != nil {
+		return fmt.Errorf("record deploy of release %s: %w", version, err)
+	}
+	return nil
+}
+
+// releaseDeployedAt returns when a release was deployed, falling back to the modification time of its directory for
+// releases deployed before the time was recorded.
+func releaseDeployedAt(appName, version string) (time.Time, error) {
+	data, err := os.ReadFile(deployedAtPath(appName, version))
+	if err == nil {
+		deployedAt, err := time.Parse(time.RFC3339Nano, strings.TrimSpace(string(data)))
+		if err != nil {
+			return time.Time{}, fmt.Errorf("parse deploy time of release %s: %w", version, err)
+		}
+		return deployedAt, nil
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return time.Time{}, fmt.Errorf("read deploy time of release %s: %w", version, err)
+	}
+	info, err := os.Stat(releaseDir(appName, version))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("stat release %s: %w", version, err)
+	}
+	return info.ModTime(), nil
+}
+
+// activateRelease makes the given release the current one using the deploy strategy declared in its manifest.
+func activateRelease(ctx context.Context, appName, version string) error {
+	m, err := loadReleaseManifest(appName, version)
+	if err != nil {
+		return err
+	}
+	if m.Deploy.BlueGreen() {
+		return activateBlueGreen(ctx, appName, version, m.HealthCheck)
+	}
+	return activateInPlace(ctx, appName, version)
+}
+
+// activateInPlace points the `current` symlink of an app at the given release, brings up its Docker Compose stack in
+// place of the running one, and installs its Caddyfile.
+func activateInPlace(ctx context.Context, appName, version string) error {
+	if err := linkReleaseShared(ctx, appName, version, false); err != nil {
+		return err
+	}
+	if err := symlink(releaseDir(appName, version), filepath.Join(appDir(appName), "current")); err != nil {
+		return err
+	}
+
+	if err := checkFileExists(
+		filepath.Join(releaseDir(appName, version), ".ship", "compose.yml"),
+	); err == nil {
+		if err := composeUp(ctx, appName, version, ""); err != nil {
+			return err
+		}
+	} else {
+		fmt.Printf("No .ship/compose.yml found, skipping Docker Compose steps\n")
+	}
+
+	if ok, err := installCaddyfile(ctx, appName, version, ""); err != nil {
+		return err
+	} else if !ok {
+		fmt.Printf("No .ship/Caddyfile found, skipping Caddy steps\n")
+	}
+
+	// A previous blue-green release runs under its own Compose project, which is not replaced by the steps above
+	slot, err := readSlot(appName)
+	if err != nil {
+		return err
+	}
+	if slot != "" {
+		if err := composeDown(ctx, composeProject(appName, slot)); err != nil {
+			return err
+		}
+		if err := removeFile(filepath.Join(appDir(appName), "slot")); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// linkReleaseShared links the app's volumes directory and its decrypted secrets into a release's .ship directory, along
+// with the secrets env file if the release's manifest asks for one. The secrets are decrypted first, so the release
+// starts with their current values. keepEnvFile renders the env file even if the release does not use it, for when a
+// release that does is still running.
+func linkReleaseShared(ctx context.Context, appName, version string, keepEnvFile bool) error {
+	m, err := loadReleaseManifest(appName, version)
+	if err != nil {
+		return err
+	}
+	if err := materializeSecrets(ctx, appName, m.Secrets.EnvFile || keepEnvFile); err != nil {
+		return err
+	}
+	// Each link is a name in the .ship directory and the path it points to
+	links := [][2]string{
+		{"volumes", filepath.Join(appDir(appName), "volumes")},
+		{"secrets", runtimeSecretsDir(appName)},
+	}
+	if m.Secrets.EnvFile {
+		links = append(links, [2]string{"secrets.env", runtimeSecretsEnvFile(appName)})
+	}
+	for _, link := range links {
+		if err := symlink(link[1], filepath.Join(releaseDir(appName, version), ".ship", link[0])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// composeUp pulls, builds, and starts the Docker Compose stack of a release. An empty project name leaves the
+// project name up to Docker Compose.
+func composeUp(ctx context.Context, appName, version, project string) error {
+	base := []string{"docker", "compose", "-f", "./.ship/compose.yml"}
+	if project != "" {
+		base = append(base, "-p", project)
+	}
+	for _, args := range [][]string{
+		{"pull"},
+		{"build", "--pull", "--build-arg", "VERSION=" + version},
+		{"up", "-d", "--remove-orphans", "--no-build"},
+	} {
+		c := append(slices.Clone(base), args...)
+		if err := execRunInDir(ctx, releaseDir(appName, version), c[0], c[1:]...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// currentCompose returns the directory of the app's current release and the `docker compose` arguments that address
+// its running project.
+func currentCompose(appName string) (string, []string, error) {
+	current, err := currentRelease(appName)
+	if err != nil {
+		return "", nil, err
+	}
+	if current == "" {
+		return "", nil, fmt.Errorf("app %q has no current release", appName)
+	}
+	dir := releaseDir(appName, current)
+	if err := checkFileExists(filepath.Join(dir, ".ship", "compose.yml")); err != nil {
+		return "", nil, fmt.Errorf("release %s of app %q has no .ship/compose.yml", current, appName)
+	}
+	slot, err := readSlot(appName)
+	if err != nil {
+		return "", nil, err
+	}
+	args := []string{"compose", "-f", "./.ship/compose.yml"}
+	if slot != "" {
+		args = append(args, "-p", composeProject(appName, slot))
+	}
+	return dir, args, nil
+}
+
+// composeDown stops and removes the containers of a Docker Compose project.
+func composeDown(ctx context.Context, project string) error {
+	if err := execRun(ctx, "docker", "compose", "-p", project, "down", "--remove-orphans"); err != nil {
+		return fmt.Errorf("stop compose project %s: %w", project, err)
+	}
+	return nil
+}
+
+// installCaddyfile installs the Caddyfile of a release as the app's Caddy site and reloads Caddy. When a project name
+// is given, `{{PROJECT}}` in the Caddyfile is replaced with it. It reports false if the release has no Caddyfile.
+func installCaddyfile(ctx context.Context, appName, version, project string) (bool, error) {
+	caddyfile, err := os.ReadFile(filepath.Join(releaseDir(appName, version), ".ship", "Caddyfile"))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("read Caddyfile of release %s: %w", version, err)
+	}
+	if project != "" {
+		caddyfile = bytes.ReplaceAll(caddyfile, []byte("{{PROJECT}}"), []byte(project))
+	}
+
+	install := exec.CommandContext(ctx, "sudo", "install", "-m", "644", "-o", "root", "-g", "root",
+		"/dev/stdin", "/root/.caddy/sites-enabled/"+appName)
+	install.Stdin = bytes.NewReader(caddyfile)
+	install.Stdout = os.Stdout
+	install.Stderr = os.Stderr
+	if err := install.Run(); err != nil {
+		return false, fmt.Errorf("install Caddyfile of release %s: %w", version, err)
+	}
+	if err := execRun(ctx, "sudo", "bash", "-c",
+		"cd /root/.caddy && docker compose exec caddy caddy reload --config /etc/caddy/Caddyfile",
+	); err != nil {
+		return false, fmt.Errorf("reload Caddy: %w", err)
+	}
+	return true, nil
+}
+
+// releaseFileChanged reports whether a file in the .ship directory differs between two releases.
+func releaseFileChanged(appName, fromVersion, toVersion, name string) bool {
+	from, fromErr := os.ReadFile(filepath.Join(releaseDir(appName, fromVersion), ".ship", name))
+	to, toErr := os.ReadFile(filepath.Join(releaseDir(appName, toVersion), ".ship", name))
+	if fromErr != nil || toErr != nil {
+		return (fromErr == nil) != (toErr == nil)
+	}
+	return !bytes.Equal(from, to)
+}
@@ -0,0 +1,205 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"syscall"
+	"text/tabwriter"
+
+	"github.com/markusylisiurunen/ship/internal/format"
+	"github.com/urfave/cli/v3"
+)
+
+type releasesArgs struct {
+	AppName    string
+	Keep       int
+	LockHolder string
+}
+
+func (a *releasesArgs) parse(cmd *cli.Command) {
+	a.AppName = cmd.String("app-name")
+	a.Keep = int(cmd.Int("keep"))
+	a.LockHolder = cmd.String("lock-holder")
+}
+
+func (a releasesArgs) validate() error {
+	if a.AppName == "" {
+		return fmt.Errorf("app name is required")
+	}
+	if !alphaNumRegexp.MatchString(a.AppName) {
+		return fmt.Errorf("app name can only contain letters, numbers, dashes, and underscores")
+	}
+	if a.Keep < 0 {
+		return fmt.Errorf("number of releases to keep cannot be negative")
+	}
+	return nil
+}
+
+type ReleasesListAction struct {
+	args releasesArgs
+}
+
+func NewReleasesListAction() *ReleasesListAction {
+	return &ReleasesListAction{}
+}
+
+func (a *ReleasesListAction) Action(_ context.Context, cmd *cli.Command) error {
+	a.args = releasesArgs{}
+	a.args.parse(cmd)
+	if err := a.args.validate(); err != nil {
+		return err
+	}
+
+	releases, err := listReleases(a.args.AppName)
+	if err != nil {
+		return err
+	}
+	if len(releases) == 0 {
+		fmt.Printf("App %q has no releases\n", a.args.AppName)
+		return nil
+	}
+
+	dirs := make(map[string]string, len(releases))
+	for _, r := range releases {
+		dirs[r.Version] = releaseDir(a.args.AppName, r.Version)
+	}
+	usages, total, err := releaseUsages(dirs)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tDEPLOYED\tSIZE\tSHARED\tCURRENT")
+	for i := len(releases) - 1; i >= 0; i-- {
+		r := releases[i]
+		current := ""
+		if r.Current {
+			current = "*"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", r.Version, r.DeployedAt.Format("2006-01-02 15:04:05"),
+			format.Bytes(usages[r.Version].Own), format.Bytes(usages[r.Version].Shared), current)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	fmt.Printf("%d release(s) using %s in total; SIZE is freed by removing a release, SHARED is used by others too\n",
+		len(releases), format.Bytes(total))
+	return nil
+}
+
+type ReleasesPruneAction struct {
+	args releasesArgs
+}
+
+func NewReleasesPruneAction() *ReleasesPruneAction {
+	return &ReleasesPruneAction{}
+}
+
+func (a *ReleasesPruneAction) Action(ctx context.Context, cmd *cli.Command) error {
+	a.args = releasesArgs{}
+	a.args.parse(cmd)
+	if err := a.args.validate(); err != nil {
+		return err
+	}
+
+	lock, err := acquireAppLock(a.args.AppName, newLockHolder("prune", a.args.LockHolder, ""))
+	if err != nil {
+		return err
+	}
+	defer lock.release()
+
+	return pruneReleases(ctx, a.args.AppName, a.args.Keep)
+}
+
+// pruneReleases removes all but the `keep` most recently deployed releases of an app. The current release is never
+// removed, and neither are the volumes and secrets directories.
+func pruneReleases(ctx context.Context, appName string, keep int) error {
+	releases, err := listReleases(appName)
+	if err != nil {
+		return err
+	}
+	pruned := 0
+	for i, r := range releases {
+		if r.Current || i >= len(releases)-keep {
+			continue
+		}
+		fmt.Printf("Removing release %s of app %q deployed at %s\n",
+			r.Version, appName, r.DeployedAt.Format("2006-01-02 15:04:05"))
+		// Containers may have written root-owned files into the release directory
+		if err := execRun(ctx, "sudo", "rm", "-rf", releaseDir(appName, r.Version)); err != nil {
+			return fmt.Errorf("remove release %s: %w", r.Version, err)
+		}
+		pruned++
+	}
+	fmt.Printf("Pruned %d release(s) of app %q\n", pruned, appName)
+	return pruneBlobs(appName)
+}
+
+// releaseUsage is the disk space taken by the files of a release.
+type releaseUsage struct {
+	// Own counts the files no other release links to, which removing the release frees.
+	Own int64
+	// Shared counts the files the release has hardlinked with other releases.
+	Shared int64
+}
+
+// releaseUsages measures the regular files of the given release directories, keyed by version, without following
+// symlinks. Files are counted once per inode, as releases hardlink the files they have in common. It also returns the
+// total size of the releases.
+func releaseUsages(dirs map[string]string) (map[string]releaseUsage, int64, error) {
+	type file struct {
+		size     int64
+		versions []string
+	}
+	files := map[uint64]*file{}
+	for version, dir := range dirs {
+		err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !d.Type().IsRegular() {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			stat, ok := info.Sys().(*syscall.Stat_t)
+			if !ok {
+				return fmt.Errorf("read inode of %s", path)
+			}
+			f, ok := files[stat.Ino]
+			if !ok {
+				f = &file{size: info.Size()}
+				files[stat.Ino] = f
+			}
+			if !slices.Contains(f.versions, version) {
+				f.versions = append(f.versions, version)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, 0, fmt.Errorf("compute size of release %s: %w", version, err)
+		}
+	}
+
+	usages := make(map[string]releaseUsage, len(dirs))
+	var total int64
+	for _, f := range files {
+		total += f.size
+		for _, version := range f.versions {
+			u := usages[version]
+			if len(f.versions) == 1 {
+				u.Own += f.size
+			} else {
+				u.Shared += f.size
+			}
+			usages[version] = u
+		}
+	}
+	return usages, total, nil
+}
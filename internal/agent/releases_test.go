@@ -0,0 +1,48 @@
+package agent
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReleaseUsages(t *testing.T) {
+	// Two releases linking the same blob, each with a file of its own and one linked twice within itself
+	root := t.TempDir()
+	blob := filepath.Join(root, "blob")
+	if err := os.WriteFile(blob, make([]byte, 100), 0o444); err != nil {
+		t.Fatal(err)
+	}
+	dirs := map[string]string{"v1": filepath.Join(root, "v1"), "v2": filepath.Join(root, "v2")}
+	for version, dir := range dirs {
+		if err := os.MkdirAll(filepath.Join(dir, "sub"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Link(blob, filepath.Join(dir, "shared")); err != nil {
+			t.Fatal(err)
+		}
+		own := filepath.Join(dir, "own")
+		if err := os.WriteFile(own, make([]byte, len(version)*10), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Link(own, filepath.Join(dir, "sub", "own")); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(blob, filepath.Join(dir, "link")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	usages, total, err := releaseUsages(dirs)
+	if err != nil {
+		t.Fatalf("releaseUsages() error = %v", err)
+	}
+	want := map[string]releaseUsage{"v1": {Own: 20, Shared: 100}, "v2": {Own: 20, Shared: 100}}
+	for version, usage := range want {
+		if usages[version] != usage {
+			t.Errorf("usage of %s = %+v, want %+v", version, usages[version], usage)
+		}
+	}
+	if total != 140 {
+		t.Errorf("total = %d, want 140", total)
+	}
+}
@@ -0,0 +1,113 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/urfave/cli/v3"
+)
+
+type rollbackArgs struct {
+	AppName    string
+	ToVersion  string
+	LockHolder string
+}
+
+func (a *rollbackArgs) parse(cmd *cli.Command) {
+	a.AppName = cmd.String("app-name")
+	a.ToVersion = cmd.String("to")
+	a.LockHolder = cmd.String("lock-holder")
+}
+
+func (a rollbackArgs) validate() error {
+	if a.AppName == "" {
+		return fmt.Errorf("app name is required")
+	}
+	if !alphaNumRegexp.MatchString(a.AppName) {
+		return fmt.Errorf("app name can only contain letters, numbers, dashes, and underscores")
+	}
+	if a.ToVersion != "" && !alphaNumRegexp.MatchString(a.ToVersion) {
+		return fmt.Errorf("version can only contain letters, numbers, dashes, and underscores")
+	}
+	return nil
+}
+
+type RollbackAction struct {
+	args rollbackArgs
+}
+
+func NewRollbackAction() *RollbackAction {
+	return &RollbackAction{}
+}
+
+func (a *RollbackAction) Action(ctx context.Context, cmd *cli.Command) error {
+	a.args = rollbackArgs{}
+	a.args.parse(cmd)
+	if err := a.args.validate(); err != nil {
+		return err
+	}
+
+	lock, err := acquireAppLock(a.args.AppName, newLockHolder("rollback", a.args.LockHolder, a.args.ToVersion))
+	if err != nil {
+		return err
+	}
+	defer lock.release()
+
+	releases, err := listReleases(a.args.AppName)
+	if err != nil {
+		return err
+	}
+	current, err := currentRelease(a.args.AppName)
+	if err != nil {
+		return err
+	}
+	if current == "" {
+		return fmt.Errorf("app %q has no current release", a.args.AppName)
+	}
+
+	target, err := a.pickTarget(releases, current)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Rolling back app %q from %s to %s\n", a.args.AppName, current, target)
+	if err := activateRelease(ctx, a.args.AppName, target); err != nil {
+		return fmt.Errorf("activate release %s: %w", target, err)
+	}
+
+	fmt.Printf("Rolled back app %q from %s to %s\n", a.args.AppName, current, target)
+	for _, name := range []string{"compose.yml", "Caddyfile"} {
+		if releaseFileChanged(a.args.AppName, current, target, name) {
+			fmt.Printf("  .ship/%s changed\n", name)
+		} else {
+			fmt.Printf("  .ship/%s unchanged\n", name)
+		}
+	}
+
+	return nil
+}
+
+// pickTarget returns the release to roll back to: the one named with --to, or the release deployed before the current
+// one.
+func (a *RollbackAction) pickTarget(releases []release, current string) (string, error) {
+	if a.args.ToVersion != "" {
+		if a.args.ToVersion == current {
+			return "", fmt.Errorf("release %s is already current", a.args.ToVersion)
+		}
+		for _, r := range releases {
+			if r.Version == a.args.ToVersion {
+				return r.Version, nil
+			}
+		}
+		return "", fmt.Errorf("release %s of app %q not found", a.args.ToVersion, a.args.AppName)
+	}
+	for i, r := range releases {
+		if r.Current {
+			if i == 0 {
+				return "", fmt.Errorf("release %s is the oldest release of app %q", current, a.args.AppName)
+			}
+			return releases[i-1].Version, nil
+		}
+	}
+	return "", fmt.Errorf("current release %s of app %q not found", current, a.args.AppName)
+}
@@ -6,11 +6,15 @@ import (
 	"fmt"
 	"os"
 	"os/exec"
+	"regexp"
 	"strings"
 
 	"github.com/urfave/cli/v3"
 )
 
+// alphaNumRegexp matches the names accepted for apps, versions, volumes, and secrets.
+var alphaNumRegexp = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
 func Execute(ctx context.Context, version string) {
 	cmd := &cli.Command{
 		Name:    "ship",
@@ -18,8 +22,15 @@ func Execute(ctx context.Context, version string) {
 		Version: version,
 		Commands: []*cli.Command{
 			{
-				Name:   "up",
-				Usage:  "reconcile the machine to an up-to-date state",
+				Name:  "up",
+				Usage: "reconcile the machine to an up-to-date state",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{Name: "plan", Usage: "only print the changes that would be made"},
+					&cli.BoolFlag{Name: "check", Usage: "only report drift from the spec, exiting with 3 if there is any"},
+					&cli.StringFlag{Name: "spec", Usage: "machine spec file path, or - to read it from stdin"},
+					&cli.StringSliceFlag{Name: "only", Usage: "only run the named steps, e.g. ufw,caddy"},
+					&cli.StringSliceFlag{Name: "skip", Usage: "skip the named steps, e.g. node"},
+				},
 				Action: NewUpAction(version).Action,
 			},
 			{
@@ -32,14 +43,194 @@ func Execute(ctx context.Context, version string) {
 			},
 			{
 				Name:  "deploy",
-				Usage: "deploy an app",
+				Usage: "deploy an app from the release archive read from stdin",
 				Flags: []cli.Flag{
 					&cli.StringFlag{Name: "app-name", Usage: "application name", Required: true},
 					&cli.StringFlag{Name: "app-version", Usage: "application version", Required: true},
 					&cli.StringSliceFlag{Name: "volume-name", Usage: "volume name (can be specified multiple times)"},
+					&cli.IntFlag{Name: "keep-releases", Usage: "number of releases to keep after deploying (0 keeps all)"},
+					&cli.StringFlag{Name: "lock-holder", Usage: "who is deploying, shown to anyone waiting for the app lock"},
 				},
 				Action: NewDeployAction().Action,
 			},
+			{
+				Name:  "rollback",
+				Usage: "roll an app back to a previous release",
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "app-name", Usage: "application name", Required: true},
+					&cli.StringFlag{Name: "to", Usage: "version to roll back to (defaults to the previous release)"},
+					&cli.StringFlag{Name: "lock-holder", Usage: "who is rolling back, shown to anyone waiting for the app lock"},
+				},
+				Action: NewRollbackAction().Action,
+			},
+			{
+				Name:  "logs",
+				Usage: "print the Docker Compose logs of an app's current release",
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "app-name", Usage: "application name", Required: true},
+					&cli.StringSliceFlag{Name: "service", Usage: "only print the logs of this service (can be specified multiple times)"},
+					&cli.BoolFlag{Name: "follow", Usage: "keep streaming new log output"},
+					&cli.StringFlag{Name: "since", Usage: "only print logs since a timestamp or a relative time, e.g. 10m"},
+					&cli.StringFlag{Name: "tail", Usage: "number of lines to print from the end of the logs of each container"},
+				},
+				Action: NewLogsAction().Action,
+			},
+			{
+				Name:   "status",
+				Usage:  "print an overview of the machine and its apps as JSON (as root)",
+				Action: NewStatusAction().Action,
+			},
+			{
+				Name:      "exec",
+				Usage:     "run a command in a running container of an app's current release",
+				ArgsUsage: "-- command [args...]",
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "app-name", Usage: "application name", Required: true},
+					&cli.StringFlag{Name: "service", Usage: "Docker Compose service to run the command in", Required: true},
+					&cli.BoolFlag{Name: "no-tty", Usage: "do not allocate a TTY in the container"},
+				},
+				Action: NewExecAction().Action,
+			},
+			{
+				Name:  "releases",
+				Usage: "manage the releases of an app",
+				Commands: []*cli.Command{
+					{
+						Name:  "list",
+						Usage: "list the releases of an app",
+						Flags: []cli.Flag{
+							&cli.StringFlag{Name: "app-name", Usage: "application name", Required: true},
+						},
+						Action: NewReleasesListAction().Action,
+					},
+					{
+						Name:  "prune",
+						Usage: "remove old releases of an app",
+						Flags: []cli.Flag{
+							&cli.StringFlag{Name: "app-name", Usage: "application name", Required: true},
+							&cli.IntFlag{Name: "keep", Usage: "number of most recent releases to keep", Value: 5},
+							&cli.StringFlag{Name: "lock-holder", Usage: "who is pruning, shown to anyone waiting for the app lock"},
+						},
+						Action: NewReleasesPruneAction().Action,
+					},
+				},
+			},
+			{
+				Name:  "secret",
+				Usage: "manage the secrets of an app",
+				Commands: []*cli.Command{
+					{
+						Name:  "set",
+						Usage: "set a secret of an app to the value read from stdin",
+						Flags: []cli.Flag{
+							&cli.StringFlag{Name: "app-name", Usage: "application name", Required: true},
+							&cli.StringFlag{Name: "secret-name", Usage: "secret name", Required: true},
+							&cli.StringFlag{Name: "lock-holder", Usage: "who is setting the secret, shown to anyone waiting for the app lock"},
+							&cli.BoolFlag{Name: "restart", Usage: "recreate the services that use the changed secrets"},
+						},
+						Action: NewSecretSetAction().Action,
+					},
+					{
+						Name:  "list",
+						Usage: "list the secrets of an app without their values",
+						Flags: []cli.Flag{
+							&cli.StringFlag{Name: "app-name", Usage: "application name", Required: true},
+						},
+						Action: NewSecretListAction().Action,
+					},
+					{
+						Name:  "get",
+						Usage: "print the value of a secret of an app",
+						Flags: []cli.Flag{
+							&cli.StringFlag{Name: "app-name", Usage: "application name", Required: true},
+							&cli.StringFlag{Name: "secret-name", Usage: "secret name", Required: true},
+						},
+						Action: NewSecretGetAction().Action,
+					},
+					{
+						Name:  "unset",
+						Usage: "remove a secret of an app",
+						Flags: []cli.Flag{
+							&cli.StringFlag{Name: "app-name", Usage: "application name", Required: true},
+							&cli.StringFlag{Name: "secret-name", Usage: "secret name", Required: true},
+							&cli.StringFlag{Name: "lock-holder", Usage: "who is removing the secret, shown to anyone waiting for the app lock"},
+							&cli.BoolFlag{Name: "restart", Usage: "recreate the services that use the changed secrets"},
+						},
+						Action: NewSecretUnsetAction().Action,
+					},
+					{
+						Name:  "import",
+						Usage: "make the secrets of an app match the JSON object of names and values read from stdin",
+						Flags: []cli.Flag{
+							&cli.StringFlag{Name: "app-name", Usage: "application name", Required: true},
+							&cli.BoolFlag{Name: "dry-run", Usage: "only print the changes"},
+							&cli.StringFlag{Name: "lock-holder", Usage: "who is importing the secrets, shown to anyone waiting for the app lock"},
+							&cli.BoolFlag{Name: "restart", Usage: "recreate the services that use the changed secrets"},
+						},
+						Action: NewSecretImportAction().Action,
+					},
+					{
+						Name:   "keygen",
+						Usage:  "create the key secrets are encrypted to unless it exists (as root)",
+						Action: NewSecretKeygenAction().Action,
+					},
+					{
+						Name:  "decrypt",
+						Usage: "print the decrypted secrets of an app as JSON (as root)",
+						Flags: []cli.Flag{
+							&cli.StringFlag{Name: "app-name", Usage: "application name", Required: true},
+							&cli.StringFlag{Name: "secret-name", Usage: "only decrypt this secret"},
+						},
+						Action: NewSecretDecryptAction().Action,
+					},
+					{
+						Name:  "materialize",
+						Usage: "decrypt the secrets of an app into its directory under " + secretsRuntimeDir + " (as root)",
+						Flags: []cli.Flag{
+							&cli.StringFlag{Name: "app-name", Usage: "application name"},
+							&cli.BoolFlag{Name: "all", Usage: "decrypt the secrets of every app"},
+							&cli.BoolFlag{Name: "env-file", Usage: "also render the secrets into secrets.env next to them"},
+						},
+						Action: NewSecretMaterializeAction().Action,
+					},
+				},
+			},
+			{
+				Name:  "blobs",
+				Usage: "manage the content-addressed files of an app",
+				Commands: []*cli.Command{
+					{
+						Name:  "missing",
+						Usage: "print the blob keys read from stdin that the app does not have",
+						Flags: []cli.Flag{
+							&cli.StringFlag{Name: "app-name", Usage: "application name", Required: true},
+						},
+						Action: NewBlobsMissingAction().Action,
+					},
+				},
+			},
+			{
+				Name:  "lock",
+				Usage: "inspect and break the deploy lock of an app",
+				Commands: []*cli.Command{
+					{
+						Name:  "status",
+						Usage: "show who holds the lock of an app",
+						Flags: []cli.Flag{
+							&cli.StringFlag{Name: "app-name", Usage: "application name", Required: true},
+						},
+						Action: NewLockStatusAction().Action,
+					},
+					{
+						Name:  "break",
+						Usage: "stop the operation holding the lock of an app and release the lock",
+						Flags: []cli.Flag{
+							&cli.StringFlag{Name: "app-name", Usage: "application name", Required: true},
+						},
+						Action: NewLockBreakAction().Action,
+					},
+				},
+			},
 		},
 	}
 	if err := cmd.Run(ctx, os.Args); err != nil {
@@ -174,3 +365,20 @@ func symlink(src, dst string) error {
 	}
 	return nil
 }
+
+// execRun runs a command, streaming its output to stdout and stderr.
+func execRun(ctx context.Context, name string, args ...string) error {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// execRunInDir runs a command in the given directory, streaming its output to stdout and stderr.
+func execRunInDir(ctx context.Context, dir string, name string, args ...string) error {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Dir = dir
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
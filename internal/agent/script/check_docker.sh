@@ -0,0 +1,9 @@
+if ! command -v docker >/dev/null 2>&1; then
+  echo "docker is not installed"
+elif ! systemctl is-active --quiet docker 2>/dev/null; then
+  echo "docker is not running"
+fi
+
+if ! id -nG deploy | tr ' ' '\n' | grep -qx docker; then
+  echo "deploy is not in the docker group"
+fi
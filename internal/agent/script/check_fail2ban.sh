@@ -0,0 +1,12 @@
+expected="[sshd]
+enabled = true
+bantime = 600
+findtime = 300
+maxretry = 8"
+if [ "$(cat /etc/fail2ban/jail.local 2>/dev/null)" != "$expected" ]; then
+  echo "/etc/fail2ban/jail.local differs from the expected jail configuration"
+fi
+
+if ! fail2ban-client status sshd >/dev/null 2>&1; then
+  echo "fail2ban jail sshd is not running"
+fi
@@ -0,0 +1,5 @@
+for home in /root /home/deploy; do
+  if [ ! -x "$home/.fzf/bin/fzf" ]; then
+    echo "fzf is not installed in $home/.fzf"
+  fi
+done
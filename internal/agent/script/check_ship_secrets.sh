@@ -0,0 +1,7 @@
+if [ ! -s /root/.ship/secrets.key ] || [ ! -s /etc/ship/secrets.pub ]; then
+  echo "the secrets key is missing"
+fi
+
+if ! systemctl is-enabled --quiet ship-secrets.service 2>/dev/null; then
+  echo "ship-secrets.service is not enabled"
+fi
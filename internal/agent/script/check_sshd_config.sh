@@ -0,0 +1,12 @@
+for setting in \
+  "PermitRootLogin no" \
+  "PasswordAuthentication no" \
+  "PubkeyAuthentication yes" \
+  "MaxAuthTries 4" \
+  "LoginGraceTime 30" \
+  "ClientAliveInterval 300" \
+  "ClientAliveCountMax 2"; do
+  if ! grep -qsx "$setting" /etc/ssh/sshd_config; then
+    echo "/etc/ssh/sshd_config does not set $setting"
+  fi
+done
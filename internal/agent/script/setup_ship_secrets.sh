@@ -0,0 +1,18 @@
+{{AGENT}} secret keygen
+
+cat > /etc/systemd/system/ship-secrets.service << EOF
+[Unit]
+Description=Decrypt app secrets into /run/ship
+Before=docker.service
+
+[Service]
+Type=oneshot
+RemainAfterExit=yes
+ExecStart={{AGENT}} secret materialize --all
+
+[Install]
+WantedBy=multi-user.target
+EOF
+
+systemctl daemon-reload
+systemctl enable ship-secrets.service
@@ -0,0 +1,368 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"filippo.io/age"
+	"github.com/urfave/cli/v3"
+)
+
+const (
+	appSecretFilePerm os.FileMode = 0o640
+	maxSecretSize                 = 1 << 20
+)
+
+type secretArgs struct {
+	AppName    string
+	SecretName string
+	LockHolder string
+	DryRun     bool
+	Restart    bool
+}
+
+func (a *secretArgs) parse(cmd *cli.Command) {
+	a.AppName = cmd.String("app-name")
+	a.SecretName = cmd.String("secret-name")
+	a.LockHolder = cmd.String("lock-holder")
+	a.DryRun = cmd.Bool("dry-run")
+	a.Restart = cmd.Bool("restart")
+}
+
+// validate checks the arguments, requiring a secret name only when the command takes one.
+func (a secretArgs) validate(needsName bool) error {
+	if a.AppName == "" {
+		return fmt.Errorf("app name is required")
+	}
+	if !alphaNumRegexp.MatchString(a.AppName) {
+		return fmt.Errorf("app name can only contain letters, numbers, dashes, and underscores")
+	}
+	if needsName && a.SecretName == "" {
+		return fmt.Errorf("secret name is required")
+	}
+	if a.SecretName != "" && !alphaNumRegexp.MatchString(a.SecretName) {
+		return fmt.Errorf("secret name can only contain letters, numbers, dashes, and underscores")
+	}
+	return nil
+}
+
+type SecretSetAction struct {
+	args secretArgs
+}
+
+func NewSecretSetAction() *SecretSetAction {
+	return &SecretSetAction{}
+}
+
+// Action reads the secret value from stdin, so that it never appears in a command line or a shell.
+func (a *SecretSetAction) Action(ctx context.Context, cmd *cli.Command) error {
+	a.args = secretArgs{}
+	a.args.parse(cmd)
+	if err := a.args.validate(true); err != nil {
+		return err
+	}
+
+	value, err := io.ReadAll(io.LimitReader(os.Stdin, maxSecretSize+1))
+	if err != nil {
+		return fmt.Errorf("read secret value: %w", err)
+	}
+	if len(value) == 0 {
+		return fmt.Errorf("secret value cannot be empty")
+	}
+	if len(value) > maxSecretSize {
+		return fmt.Errorf("secret value cannot be larger than %d bytes", maxSecretSize)
+	}
+
+	lock, err := acquireAppLock(a.args.AppName, newLockHolder("secret set", a.args.LockHolder, ""))
+	if err != nil {
+		return err
+	}
+	defer lock.release()
+
+	recipient, err := loadSecretsRecipient(ctx)
+	if err != nil {
+		return err
+	}
+	if err := ensureDirExistsAndOwnedBy(secretsDir(a.args.AppName), appSecretsDirPerm, "deploy"); err != nil {
+		return err
+	}
+	if err := writeSecret(a.args.AppName, a.args.SecretName, value, recipient); err != nil {
+		return err
+	}
+	fmt.Printf("Secret %q of app %q set\n", a.args.SecretName, a.args.AppName)
+
+	return refreshSecrets(ctx, a.args.AppName, a.args.Restart, []string{a.args.SecretName})
+}
+
+type SecretListAction struct {
+	args secretArgs
+}
+
+func NewSecretListAction() *SecretListAction {
+	return &SecretListAction{}
+}
+
+func (a *SecretListAction) Action(_ context.Context, cmd *cli.Command) error {
+	a.args = secretArgs{}
+	a.args.parse(cmd)
+	if err := a.args.validate(false); err != nil {
+		return err
+	}
+
+	secrets, err := listSecrets(a.args.AppName)
+	if err != nil {
+		return err
+	}
+	if len(secrets) == 0 {
+		fmt.Printf("App %q has no secrets\n", a.args.AppName)
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tMODIFIED")
+	for _, name := range slices.Sorted(maps.Keys(secrets)) {
+		fmt.Fprintf(w, "%s\t%s\n", name, secrets[name].Format("2006-01-02 15:04:05"))
+	}
+	return w.Flush()
+}
+
+type SecretGetAction struct {
+	args secretArgs
+}
+
+func NewSecretGetAction() *SecretGetAction {
+	return &SecretGetAction{}
+}
+
+// Action writes the secret value to stdout as is.
+func (a *SecretGetAction) Action(ctx context.Context, cmd *cli.Command) error {
+	a.args = secretArgs{}
+	a.args.parse(cmd)
+	if err := a.args.validate(true); err != nil {
+		return err
+	}
+
+	if err := checkFileExists(filepath.Join(secretsDir(a.args.AppName), a.args.SecretName)); err != nil {
+		return fmt.Errorf("secret %q of app %q not found", a.args.SecretName, a.args.AppName)
+	}
+	values, err := decryptSecrets(ctx, a.args.AppName, a.args.SecretName)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stdout.Write(values[a.args.SecretName]); err != nil {
+		return fmt.Errorf("write secret %q: %w", a.args.SecretName, err)
+	}
+	return nil
+}
+
+type SecretUnsetAction struct {
+	args secretArgs
+}
+
+func NewSecretUnsetAction() *SecretUnsetAction {
+	return &SecretUnsetAction{}
+}
+
+func (a *SecretUnsetAction) Action(ctx context.Context, cmd *cli.Command) error {
+	a.args = secretArgs{}
+	a.args.parse(cmd)
+	if err := a.args.validate(true); err != nil {
+		return err
+	}
+
+	lock, err := acquireAppLock(a.args.AppName, newLockHolder("secret unset", a.args.LockHolder, ""))
+	if err != nil {
+		return err
+	}
+	defer lock.release()
+
+	path := filepath.Join(secretsDir(a.args.AppName), a.args.SecretName)
+	if err := checkFileExists(path); err != nil {
+		return fmt.Errorf("secret %q of app %q not found", a.args.SecretName, a.args.AppName)
+	}
+	if err := removeFile(path); err != nil {
+		return err
+	}
+	fmt.Printf("Secret %q of app %q unset\n", a.args.SecretName, a.args.AppName)
+	return refreshSecrets(ctx, a.args.AppName, a.args.Restart, []string{a.args.SecretName})
+}
+
+type SecretImportAction struct {
+	args secretArgs
+}
+
+func NewSecretImportAction() *SecretImportAction {
+	return &SecretImportAction{}
+}
+
+// Action reads a JSON object of secret names and values from stdin and makes the app's secrets match it, printing the
+// names that are added, changed, and removed. Values are never printed.
+func (a *SecretImportAction) Action(ctx context.Context, cmd *cli.Command) error {
+	a.args = secretArgs{}
+	a.args.parse(cmd)
+	if err := a.args.validate(false); err != nil {
+		return err
+	}
+
+	var values map[string]string
+	if err := json.NewDecoder(io.LimitReader(os.Stdin, 64*maxSecretSize)).Decode(&values); err != nil {
+		return fmt.Errorf("parse secrets: %w", err)
+	}
+	for name, value := range values {
+		if !alphaNumRegexp.MatchString(name) {
+			return fmt.Errorf("secret name %q can only contain letters, numbers, dashes, and underscores", name)
+		}
+		if value == "" {
+			return fmt.Errorf("value of secret %q cannot be empty", name)
+		}
+		if len(value) > maxSecretSize {
+			return fmt.Errorf("value of secret %q cannot be larger than %d bytes", name, maxSecretSize)
+		}
+	}
+
+	if !a.args.DryRun {
+		lock, err := acquireAppLock(a.args.AppName, newLockHolder("secret import", a.args.LockHolder, ""))
+		if err != nil {
+			return err
+		}
+		defer lock.release()
+	}
+
+	// Compare the imported secrets with the current ones
+	current, err := listSecrets(a.args.AppName)
+	if err != nil {
+		return err
+	}
+	existing := map[string][]byte{}
+	if len(current) > 0 {
+		if existing, err = decryptSecrets(ctx, a.args.AppName, ""); err != nil {
+			return err
+		}
+	}
+	var added, changed, removed []string
+	for _, name := range slices.Sorted(maps.Keys(values)) {
+		if _, ok := current[name]; !ok {
+			added = append(added, name)
+			continue
+		}
+		if !bytes.Equal(existing[name], []byte(values[name])) {
+			changed = append(changed, name)
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(current)) {
+		if _, ok := values[name]; !ok {
+			removed = append(removed, name)
+		}
+	}
+	for _, change := range []struct {
+		sign  string
+		names []string
+	}{{"+", added}, {"~", changed}, {"-", removed}} {
+		for _, name := range change.names {
+			fmt.Printf("  %s %s\n", change.sign, name)
+		}
+	}
+	fmt.Printf("%d to add, %d to change, %d to remove\n", len(added), len(changed), len(removed))
+	if a.args.DryRun {
+		return nil
+	}
+
+	// Apply the changes
+	recipient, err := loadSecretsRecipient(ctx)
+	if err != nil {
+		return err
+	}
+	if err := ensureDirExistsAndOwnedBy(secretsDir(a.args.AppName), appSecretsDirPerm, "deploy"); err != nil {
+		return err
+	}
+	for _, name := range slices.Concat(added, changed) {
+		if err := writeSecret(a.args.AppName, name, []byte(values[name]), recipient); err != nil {
+			return err
+		}
+	}
+	for _, name := range removed {
+		if err := removeFile(filepath.Join(secretsDir(a.args.AppName), name)); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("Imported secrets of app %q\n", a.args.AppName)
+	return refreshSecrets(ctx, a.args.AppName, a.args.Restart, slices.Concat(added, changed, removed))
+}
+
+// listSecrets returns the names of an app's secrets with their modification times.
+func listSecrets(appName string) (map[string]time.Time, error) {
+	entries, err := listDirEntries(secretsDir(appName))
+	if err != nil {
+		return nil, err
+	}
+	secrets := map[string]time.Time{}
+	for _, entry := range entries {
+		// Dotfiles are secrets still being written
+		if !entry.Type().IsRegular() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, fmt.Errorf("stat secret %s: %w", entry.Name(), err)
+		}
+		secrets[entry.Name()] = info.ModTime()
+	}
+	return secrets, nil
+}
+
+func secretsDir(appName string) string {
+	return filepath.Join(appDir(appName), "secrets")
+}
+
+// writeSecret encrypts a secret and atomically replaces the file holding it, so that a release never reads a partially
+// written value.
+func writeSecret(appName, name string, value []byte, recipient age.Recipient) error {
+	data, err := encryptSecret(recipient, value)
+	if err != nil {
+		return fmt.Errorf("encrypt secret %q: %w", name, err)
+	}
+	if err := writeFileAtomic(secretsDir(appName), name, data, appSecretFilePerm, nil); err != nil {
+		return fmt.Errorf("write secret %q: %w", name, err)
+	}
+	return nil
+}
+
+// writeFileAtomic replaces a file in dir through a temporary dotfile. The prepare function, if any, is called with
+// the temporary file's path before it takes the file's place.
+func writeFileAtomic(dir, name string, data []byte, perm os.FileMode, prepare func(path string) error) error {
+	tmp, err := os.CreateTemp(dir, "."+name+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if prepare != nil {
+		if err := prepare(tmp.Name()); err != nil {
+			return err
+		}
+	}
+	return os.Rename(tmp.Name(), filepath.Join(dir, name))
+}
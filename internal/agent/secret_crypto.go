@@ -0,0 +1,399 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"filippo.io/age"
+	"github.com/urfave/cli/v3"
+)
+
+// Secrets are stored encrypted to a per-machine age key. Only root can read the key, so the agent re-runs itself with
+// sudo to decrypt, and the decrypted secrets only ever exist on the tmpfs under /run.
+const (
+	secretsKeyPath       = "/root/.ship/secrets.key"
+	secretsRecipientPath = "/etc/ship/secrets.pub"
+	secretsRuntimeDir    = "/run/ship"
+)
+
+// ageHeader starts every age-encrypted file. Secrets without it were written before encryption at rest.
+var ageHeader = []byte("age-encryption.org/v1\n")
+
+// runtimeSecretsDir holds the decrypted secrets of an app that its releases link to.
+func runtimeSecretsDir(appName string) string {
+	return filepath.Join(secretsRuntimeDir, appName, "secrets")
+}
+
+type SecretKeygenAction struct{}
+
+func NewSecretKeygenAction() *SecretKeygenAction {
+	return &SecretKeygenAction{}
+}
+
+// Action creates the machine's secrets key unless it exists, and publishes its public half.
+func (a *SecretKeygenAction) Action(_ context.Context, _ *cli.Command) error {
+	if err := requireRoot(); err != nil {
+		return err
+	}
+
+	identity, err := loadSecretsIdentity()
+	if errors.Is(err, os.ErrNotExist) {
+		if identity, err = age.GenerateX25519Identity(); err != nil {
+			return fmt.Errorf("generate secrets key: %w", err)
+		}
+		if err := os.MkdirAll(filepath.Dir(secretsKeyPath), 0o700); err != nil {
+			return fmt.Errorf("create directory for secrets key: %w", err)
+		}
+		if err := os.WriteFile(secretsKeyPath, []byte(identity.String()+"\n"), 0o600); err != nil {
+			return fmt.Errorf("write secrets key: %w", err)
+		}
+		fmt.Printf("Generated secrets key %s\n", secretsKeyPath)
+	} else if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(secretsRecipientPath), 0o755); err != nil {
+		return fmt.Errorf("create directory for secrets recipient: %w", err)
+	}
+	if err := os.WriteFile(secretsRecipientPath, []byte(identity.Recipient().String()+"\n"), 0o644); err != nil {
+		return fmt.Errorf("write secrets recipient: %w", err)
+	}
+	return nil
+}
+
+type SecretDecryptAction struct {
+	args secretArgs
+}
+
+func NewSecretDecryptAction() *SecretDecryptAction {
+	return &SecretDecryptAction{}
+}
+
+// Action prints the decrypted secrets of an app, or only the named one, as a JSON object of names and base64-encoded
+// values.
+func (a *SecretDecryptAction) Action(_ context.Context, cmd *cli.Command) error {
+	a.args = secretArgs{}
+	a.args.parse(cmd)
+	if err := a.args.validate(false); err != nil {
+		return err
+	}
+	if err := requireRoot(); err != nil {
+		return err
+	}
+
+	values, err := readSecrets(a.args.AppName)
+	if err != nil {
+		return err
+	}
+	if a.args.SecretName != "" {
+		value, ok := values[a.args.SecretName]
+		if !ok {
+			return fmt.Errorf("secret %q of app %q not found", a.args.SecretName, a.args.AppName)
+		}
+		values = map[string][]byte{a.args.SecretName: value}
+	}
+	return json.NewEncoder(os.Stdout).Encode(values)
+}
+
+type SecretMaterializeAction struct {
+	args secretArgs
+}
+
+func NewSecretMaterializeAction() *SecretMaterializeAction {
+	return &SecretMaterializeAction{}
+}
+
+// Action decrypts the secrets of an app, or of every app with --all, into the app's runtime directory. With --all,
+// the manifest of each app's current release decides whether its env file is rendered.
+func (a *SecretMaterializeAction) Action(_ context.Context, cmd *cli.Command) error {
+	a.args = secretArgs{}
+	a.args.parse(cmd)
+	if err := requireRoot(); err != nil {
+		return err
+	}
+
+	if !cmd.Bool("all") {
+		if err := a.args.validate(false); err != nil {
+			return err
+		}
+		return materializeAppSecrets(a.args.AppName, cmd.Bool("env-file"))
+	}
+
+	entries, err := listDirEntries(appsDir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() || !alphaNumRegexp.MatchString(entry.Name()) {
+			continue
+		}
+		envFile := false
+		current, err := currentRelease(entry.Name())
+		if err != nil {
+			return err
+		}
+		if current != "" {
+			m, err := loadReleaseManifest(entry.Name(), current)
+			if err != nil {
+				return err
+			}
+			envFile = m.Secrets.EnvFile
+		}
+		if err := materializeAppSecrets(entry.Name(), envFile); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// materializeAppSecrets makes the runtime directory of an app hold exactly its decrypted secrets, and renders them
+// into the app's env file if asked to. The directory is updated in place, so containers that mount it see the new
+// values.
+func materializeAppSecrets(appName string, envFile bool) error {
+	values, err := readSecrets(appName)
+	if err != nil {
+		return err
+	}
+
+	dir := runtimeSecretsDir(appName)
+	if err := os.MkdirAll(filepath.Dir(dir), 0o755); err != nil {
+		return fmt.Errorf("create runtime secrets directory: %w", err)
+	}
+	if err := os.MkdirAll(dir, appSecretsDirPerm); err != nil {
+		return fmt.Errorf("create runtime secrets directory: %w", err)
+	}
+	if err := chownDeploy(dir); err != nil {
+		return err
+	}
+	if err := os.Chmod(dir, appSecretsDirPerm); err != nil {
+		return fmt.Errorf("set permissions on %s: %w", dir, err)
+	}
+	for _, name := range slices.Sorted(maps.Keys(values)) {
+		if err := writeFileAtomic(dir, name, values[name], appSecretFilePerm, chownDeploy); err != nil {
+			return fmt.Errorf("write secret %q: %w", name, err)
+		}
+	}
+	entries, err := listDirEntries(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if _, ok := values[entry.Name()]; !ok {
+			if err := removeFile(filepath.Join(dir, entry.Name())); err != nil {
+				return err
+			}
+		}
+	}
+
+	envPath := runtimeSecretsEnvFile(appName)
+	if !envFile {
+		return removeFile(envPath)
+	}
+	data, skipped := renderEnvFile(values)
+	for _, name := range skipped {
+		fmt.Printf("Secret %q is not a valid environment variable name, leaving it out of secrets.env\n", name)
+	}
+	if err := writeFileAtomic(filepath.Dir(envPath), filepath.Base(envPath), data, appSecretFilePerm, chownDeploy); err != nil {
+		return fmt.Errorf("write secrets env file: %w", err)
+	}
+	return nil
+}
+
+// readSecrets decrypts every secret of an app. It needs the secrets key, so it only works as root.
+func readSecrets(appName string) (map[string][]byte, error) {
+	secrets, err := listSecrets(appName)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		values   = make(map[string][]byte, len(secrets))
+		identity *age.X25519Identity
+	)
+	for name := range secrets {
+		data, err := os.ReadFile(filepath.Join(secretsDir(appName), name))
+		if err != nil {
+			return nil, fmt.Errorf("read secret %q: %w", name, err)
+		}
+		if !bytes.HasPrefix(data, ageHeader) {
+			values[name] = data
+			continue
+		}
+		if identity == nil {
+			if identity, err = loadSecretsIdentity(); err != nil {
+				return nil, err
+			}
+		}
+		r, err := age.Decrypt(bytes.NewReader(data), identity)
+		if err != nil {
+			return nil, fmt.Errorf("decrypt secret %q: %w", name, err)
+		}
+		value, err := io.ReadAll(r)
+		if err != nil {
+			return nil, fmt.Errorf("decrypt secret %q: %w", name, err)
+		}
+		values[name] = value
+	}
+	return values, nil
+}
+
+// decryptSecrets returns the decrypted secrets of an app, or only the named one, by running the agent as root.
+func decryptSecrets(ctx context.Context, appName, secretName string) (map[string][]byte, error) {
+	args := []string{"secret", "decrypt", "--app-name", appName}
+	if secretName != "" {
+		args = append(args, "--secret-name", secretName)
+	}
+	var out bytes.Buffer
+	if err := runAgentAsRoot(ctx, &out, args...); err != nil {
+		return nil, fmt.Errorf("decrypt secrets of app %q: %w", appName, err)
+	}
+	var values map[string][]byte
+	if err := json.Unmarshal(out.Bytes(), &values); err != nil {
+		return nil, fmt.Errorf("parse decrypted secrets of app %q: %w", appName, err)
+	}
+	return values, nil
+}
+
+// materializeSecrets decrypts the secrets of an app into its runtime directory, and its env file if asked to, by
+// running the agent as root. Plaintext secrets left from before encryption at rest are encrypted first.
+func materializeSecrets(ctx context.Context, appName string, envFile bool) error {
+	if err := encryptPlaintextSecrets(ctx, appName); err != nil {
+		return err
+	}
+	args := []string{"secret", "materialize", "--app-name", appName}
+	if envFile {
+		args = append(args, "--env-file")
+	}
+	if err := runAgentAsRoot(ctx, os.Stdout, args...); err != nil {
+		return fmt.Errorf("decrypt secrets of app %q: %w", appName, err)
+	}
+	return nil
+}
+
+// encryptPlaintextSecrets encrypts the secrets of an app that were written before encryption at rest.
+func encryptPlaintextSecrets(ctx context.Context, appName string) error {
+	secrets, err := listSecrets(appName)
+	if err != nil {
+		return err
+	}
+	var recipient age.Recipient
+	for _, name := range slices.Sorted(maps.Keys(secrets)) {
+		data, err := os.ReadFile(filepath.Join(secretsDir(appName), name))
+		if err != nil {
+			return fmt.Errorf("read secret %q: %w", name, err)
+		}
+		if bytes.HasPrefix(data, ageHeader) {
+			continue
+		}
+		if recipient == nil {
+			if recipient, err = loadSecretsRecipient(ctx); err != nil {
+				return err
+			}
+		}
+		fmt.Printf("Encrypting plaintext secret %q of app %q\n", name, appName)
+		if err := writeSecret(appName, name, data, recipient); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadSecretsRecipient returns the public half of the machine's secrets key, creating the key if it does not exist.
+func loadSecretsRecipient(ctx context.Context) (age.Recipient, error) {
+	data, err := os.ReadFile(secretsRecipientPath)
+	if errors.Is(err, os.ErrNotExist) {
+		if err := runAgentAsRoot(ctx, os.Stdout, "secret", "keygen"); err != nil {
+			return nil, fmt.Errorf("create secrets key: %w", err)
+		}
+		data, err = os.ReadFile(secretsRecipientPath)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read secrets recipient: %w", err)
+	}
+	recipient, err := age.ParseX25519Recipient(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, fmt.Errorf("parse secrets recipient %s: %w", secretsRecipientPath, err)
+	}
+	return recipient, nil
+}
+
+func loadSecretsIdentity() (*age.X25519Identity, error) {
+	data, err := os.ReadFile(secretsKeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("read secrets key: %w", err)
+	}
+	identity, err := age.ParseX25519Identity(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, fmt.Errorf("parse secrets key %s: %w", secretsKeyPath, err)
+	}
+	return identity, nil
+}
+
+// encryptSecret encrypts a secret value to the machine's secrets key.
+func encryptSecret(recipient age.Recipient, value []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := age.Encrypt(&buf, recipient)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(value); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// runAgentAsRoot runs this agent binary with sudo, writing its stdout to the given writer.
+func runAgentAsRoot(ctx context.Context, stdout io.Writer, args ...string) error {
+	self, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("locate agent binary: %w", err)
+	}
+	cmd := exec.CommandContext(ctx, "sudo", append([]string{self}, args...)...)
+	cmd.Stdout = stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+func requireRoot() error {
+	if os.Geteuid() != 0 {
+		return fmt.Errorf("this command must run as root")
+	}
+	return nil
+}
+
+// chownDeploy gives a file to the deploy user.
+func chownDeploy(path string) error {
+	if err := exec.Command("chown", "deploy:deploy", path).Run(); err != nil {
+		return fmt.Errorf("set ownership on %s: %w", path, err)
+	}
+	return nil
+}
+
+// refreshSecrets updates the decrypted secrets of the app's current release after the named ones change, and recreates
+// the services using them if asked to. Linking them again also moves a release deployed before encryption at rest off
+// the encrypted files.
+func refreshSecrets(ctx context.Context, appName string, restart bool, changed []string) error {
+	current, err := currentRelease(appName)
+	if err != nil || current == "" {
+		return err
+	}
+	if err := linkReleaseShared(ctx, appName, current, false); err != nil {
+		return err
+	}
+	if !restart || len(changed) == 0 {
+		return nil
+	}
+	return restartAffectedServices(ctx, appName, current, changed)
+}
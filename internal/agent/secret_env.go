@@ -0,0 +1,137 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// envNameRegexp matches the secret names that can be used as environment variable names.
+var envNameRegexp = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// runtimeSecretsEnvFile holds the decrypted secrets of an app rendered as an env file.
+func runtimeSecretsEnvFile(appName string) string {
+	return filepath.Join(secretsRuntimeDir, appName, "secrets.env")
+}
+
+// renderEnvFile renders secrets as an env file for Docker Compose. Values are single-quoted so that they are taken
+// literally. Values that cannot be single-quoted are double-quoted with escapes, and `$` doubled so that Compose does
+// not interpolate it. It also returns the names that are not valid environment variable names and were left out.
+func renderEnvFile(values map[string][]byte) ([]byte, []string) {
+	var (
+		buf     bytes.Buffer
+		skipped []string
+	)
+	for _, name := range slices.Sorted(maps.Keys(values)) {
+		if !envNameRegexp.MatchString(name) {
+			skipped = append(skipped, name)
+			continue
+		}
+		value := string(values[name])
+		if !strings.Contains(value, "'") && !strings.HasSuffix(value, `\`) {
+			fmt.Fprintf(&buf, "%s='%s'\n", name, value)
+			continue
+		}
+		value = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "$", "$$", "\n", `\n`, "\r", `\r`).Replace(value)
+		fmt.Fprintf(&buf, "%s=\"%s\"\n", name, value)
+	}
+	return buf.Bytes(), skipped
+}
+
+// composeEnvFile is an `env_file` entry of a Compose service, which is a plain path in older Compose versions.
+type composeEnvFile string
+
+func (f *composeEnvFile) UnmarshalJSON(data []byte) error {
+	var path string
+	if err := json.Unmarshal(data, &path); err == nil {
+		*f = composeEnvFile(path)
+		return nil
+	}
+	var entry struct {
+		Path string `json:"path"`
+	}
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return err
+	}
+	*f = composeEnvFile(entry.Path)
+	return nil
+}
+
+// composeConfig is the part of the resolved Compose configuration that tells which services use the app's secrets.
+type composeConfig struct {
+	Services map[string]struct {
+		EnvFile []composeEnvFile `json:"env_file"`
+		Secrets []struct {
+			Source string `json:"source"`
+		} `json:"secrets"`
+	} `json:"services"`
+	Secrets map[string]struct {
+		File string `json:"file"`
+	} `json:"secrets"`
+}
+
+// restartAffectedServices recreates the services of a running release that load one of the changed secrets, either
+// through .ship/secrets.env or as a Compose secret read from .ship/secrets. Containers only read their environment
+// when they are created, so restarting them is not enough.
+func restartAffectedServices(ctx context.Context, appName, version string, changed []string) error {
+	dir := releaseDir(appName, version)
+	if err := checkFileExists(filepath.Join(dir, ".ship", "compose.yml")); err != nil {
+		return nil
+	}
+	slot, err := readSlot(appName)
+	if err != nil {
+		return err
+	}
+	args := []string{"compose", "-f", "./.ship/compose.yml"}
+	if slot != "" {
+		args = append(args, "-p", composeProject(appName, slot))
+	}
+
+	cmd := exec.CommandContext(ctx, "docker", append(slices.Clone(args), "config", "--format", "json")...)
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		return fmt.Errorf("docker compose config: %w", err)
+	}
+	var config composeConfig
+	if err := json.Unmarshal(out, &config); err != nil {
+		return fmt.Errorf("parse docker compose config: %w", err)
+	}
+
+	shipDir := filepath.Join(dir, ".ship")
+	envChanged := slices.ContainsFunc(changed, envNameRegexp.MatchString)
+	var services []string
+	for _, name := range slices.Sorted(maps.Keys(config.Services)) {
+		service := config.Services[name]
+		affected := envChanged && slices.ContainsFunc(service.EnvFile, func(f composeEnvFile) bool {
+			return filepath.Clean(string(f)) == filepath.Join(shipDir, "secrets.env")
+		})
+		for _, secret := range service.Secrets {
+			file := filepath.Clean(config.Secrets[secret.Source].File)
+			if filepath.Dir(file) == filepath.Join(shipDir, "secrets") && slices.Contains(changed, filepath.Base(file)) {
+				affected = true
+			}
+		}
+		if affected {
+			services = append(services, name)
+		}
+	}
+	if len(services) == 0 {
+		fmt.Printf("No services of app %q use the changed secrets\n", appName)
+		return nil
+	}
+
+	fmt.Printf("Recreating service(s) %s of app %q to pick up the changed secrets\n", strings.Join(services, ", "), appName)
+	args = append(args, "up", "-d", "--no-deps", "--no-build", "--force-recreate")
+	if err := execRunInDir(ctx, dir, "docker", append(args, services...)...); err != nil {
+		return fmt.Errorf("recreate services of app %q: %w", appName, err)
+	}
+	return nil
+}
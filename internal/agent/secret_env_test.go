@@ -0,0 +1,53 @@
+package agent
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestRenderEnvFile(t *testing.T) {
+	tests := []struct {
+		name        string
+		values      map[string]string
+		want        string
+		wantSkipped []string
+	}{
+		{name: "no secrets", values: map[string]string{}, want: ""},
+		{
+			name:   "sorted and single-quoted",
+			values: map[string]string{"B": "2", "A": "one $X \"two\"\nthree"},
+			want:   "A='one $X \"two\"\nthree'\nB='2'\n",
+		},
+		{
+			name:   "single quote is double-quoted and escaped",
+			values: map[string]string{"A": "it's $HOME\n\"x\"\\"},
+			want:   "A=\"it's $$HOME\\n\\\"x\\\"\\\\\"\n",
+		},
+		{
+			name:   "trailing backslash is double-quoted",
+			values: map[string]string{"A": `C:\`},
+			want:   "A=\"C:\\\\\"\n",
+		},
+		{
+			name:        "invalid names are skipped",
+			values:      map[string]string{"ok_1": "x", "has-dash": "y", "1digit": "z", "_under": "w"},
+			want:        "_under='w'\nok_1='x'\n",
+			wantSkipped: []string{"1digit", "has-dash"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			values := make(map[string][]byte, len(tt.values))
+			for k, v := range tt.values {
+				values[k] = []byte(v)
+			}
+			got, skipped := renderEnvFile(values)
+			if string(got) != tt.want {
+				t.Errorf("renderEnvFile() = %q, want %q", got, tt.want)
+			}
+			if !slices.Equal(skipped, tt.wantSkipped) {
+				t.Errorf("renderEnvFile() skipped = %q, want %q", skipped, tt.wantSkipped)
+			}
+		})
+	}
+}
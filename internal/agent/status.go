@@ -0,0 +1,265 @@
+package agent
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/markusylisiurunen/ship/internal/status"
+	"github.com/urfave/cli/v3"
+)
+
+type StatusAction struct{}
+
+func NewStatusAction() *StatusAction {
+	return &StatusAction{}
+}
+
+// Action prints an overview of the machine and its apps as JSON. Whatever cannot be determined is reported as a
+// warning instead of failing the whole overview.
+func (a *StatusAction) Action(ctx context.Context, _ *cli.Command) error {
+	if err := requireRoot(); err != nil {
+		return err
+	}
+
+	var s status.Machine
+	warn := func(err error) {
+		s.Warnings = append(s.Warnings, err.Error())
+	}
+	if hostname, err := os.Hostname(); err != nil {
+		warn(fmt.Errorf("get hostname: %w", err))
+	} else {
+		s.Hostname = hostname
+	}
+	var err error
+	if s.RootAgents, err = agentVersions("/root/.ship"); err != nil {
+		warn(err)
+	}
+	if s.DeployAgents, err = agentVersions("/home/deploy/.ship"); err != nil {
+		warn(err)
+	}
+	if s.Apps, err = appStatuses(ctx, warn); err != nil {
+		warn(err)
+	}
+	if s.Caddy, err = caddyStatus(ctx); err != nil {
+		warn(err)
+	}
+	if s.Disk, err = diskUsage("/"); err != nil {
+		warn(err)
+	}
+	if s.Memory, err = memoryUsage(); err != nil {
+		warn(err)
+	}
+	if s.Upgrades, err = pendingUpgrades(ctx); err != nil {
+		warn(err)
+	}
+	s.RebootRequired = checkFileExists("/var/run/reboot-required") == nil
+
+	return json.NewEncoder(os.Stdout).Encode(s)
+}
+
+// agentVersions lists the versions of the agent binary installed under a directory.
+func agentVersions(dir string) ([]string, error) {
+	entries, err := listDirEntries(dir)
+	if err != nil {
+		return nil, err
+	}
+	versions := []string{}
+	for _, entry := range entries {
+		if entry.IsDir() && checkFileExists(filepath.Join(dir, entry.Name(), "agent")) == nil {
+			versions = append(versions, entry.Name())
+		}
+	}
+	return versions, nil
+}
+
+// appStatuses reports the current release and the containers of every app. Problems with a single app are passed to
+// warn, so that the other apps are still reported.
+func appStatuses(ctx context.Context, warn func(error)) ([]status.App, error) {
+	entries, err := listDirEntries(appsDir)
+	if err != nil {
+		return nil, err
+	}
+	apps := []status.App{}
+	for _, entry := range entries {
+		if !entry.IsDir() || !alphaNumRegexp.MatchString(entry.Name()) {
+			continue
+		}
+		app := status.App{Name: entry.Name(), Services: []status.Service{}}
+		current, err := currentRelease(app.Name)
+		if err != nil {
+			warn(err)
+			continue
+		}
+		if current == "" {
+			apps = append(apps, app)
+			continue
+		}
+		app.Release = current
+		dir := releaseDir(app.Name, current)
+		if deployedAt, err := releaseDeployedAt(app.Name, current); err != nil {
+			warn(fmt.Errorf("app %q: %w", app.Name, err))
+		} else {
+			app.DeployedAt = deployedAt
+		}
+		slot, err := readSlot(app.Name)
+		if err != nil {
+			warn(err)
+		} else if slot != "" {
+			app.Project = composeProject(app.Name, slot)
+		}
+		if checkFileExists(filepath.Join(dir, ".ship", "compose.yml")) == nil {
+			if app.Services, err = serviceStatuses(ctx, dir, app.Project); err != nil {
+				warn(fmt.Errorf("app %q: %w", app.Name, err))
+			}
+		}
+		apps = append(apps, app)
+	}
+	return apps, nil
+}
+
+// serviceStatuses reports the containers of a release's Docker Compose stack with their restart counts.
+func serviceStatuses(ctx context.Context, dir, project string) ([]status.Service, error) {
+	containers, err := composePs(ctx, dir, project)
+	if err != nil {
+		return []status.Service{}, err
+	}
+	restarts, err := restartCounts(ctx, containers)
+	if err != nil {
+		return []status.Service{}, err
+	}
+	services := make([]status.Service, 0, len(containers))
+	for _, c := range containers {
+		services = append(services, status.Service{
+			Name:     c.Service,
+			State:    c.State,
+			Health:   c.Health,
+			Restarts: restarts[c.Name],
+		})
+	}
+	slices.SortFunc(services, func(a, b status.Service) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return services, nil
+}
+
+// restartCounts returns how many times Docker has restarted each of the containers, by container name.
+func restartCounts(ctx context.Context, containers []composeContainer) (map[string]int, error) {
+	counts := map[string]int{}
+	if len(containers) == 0 {
+		return counts, nil
+	}
+	args := []string{"inspect"}
+	for _, c := range containers {
+		args = append(args, c.Name)
+	}
+	out, err := exec.CommandContext(ctx, "docker", args...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("docker inspect: %w", err)
+	}
+	var inspected []struct {
+		Name         string `json:"Name"`
+		RestartCount int    `json:"RestartCount"`
+	}
+	if err := json.Unmarshal(out, &inspected); err != nil {
+		return nil, fmt.Errorf("parse docker inspect output: %w", err)
+	}
+	for _, c := range inspected {
+		counts[strings.TrimPrefix(c.Name, "/")] = c.RestartCount
+	}
+	return counts, nil
+}
+
+// caddyStatus reports the state of the Caddy container and the sites installed for it.
+func caddyStatus(ctx context.Context) (status.Caddy, error) {
+	c := status.Caddy{State: "missing", Sites: []string{}}
+	entries, err := listDirEntries("/root/.caddy/sites-enabled")
+	if err != nil {
+		return c, err
+	}
+	for _, entry := range entries {
+		c.Sites = append(c.Sites, entry.Name())
+	}
+
+	cmd := exec.CommandContext(ctx, "docker", "compose", "ps", "--all", "--format", "json")
+	cmd.Dir = "/root/.caddy"
+	out, err := cmd.Output()
+	if err != nil {
+		return c, fmt.Errorf("docker compose ps for Caddy: %w", err)
+	}
+	containers, err := parseComposePs(out)
+	if err != nil {
+		return c, err
+	}
+	for _, container := range containers {
+		if container.Service == "caddy" {
+			c.State = container.State
+		}
+	}
+	return c, nil
+}
+
+// diskUsage reports the used and total size of the file system holding a path.
+func diskUsage(path string) (status.Usage, error) {
+	var fs syscall.Statfs_t
+	if err := syscall.Statfs(path, &fs); err != nil {
+		return status.Usage{}, fmt.Errorf("stat file system of %s: %w", path, err)
+	}
+	total := fs.Blocks * uint64(fs.Bsize)
+	return status.Usage{Used: total - fs.Bavail*uint64(fs.Bsize), Total: total}, nil
+}
+
+// memoryUsage reports the memory in use, which excludes what the kernel can reclaim, and the total memory.
+func memoryUsage() (status.Usage, error) {
+	file, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return status.Usage{}, fmt.Errorf("read memory usage: %w", err)
+	}
+	defer file.Close()
+	values := map[string]uint64{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		kb, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		values[strings.TrimSuffix(fields[0], ":")] = kb * 1024
+	}
+	if err := scanner.Err(); err != nil {
+		return status.Usage{}, fmt.Errorf("read memory usage: %w", err)
+	}
+	total, available := values["MemTotal"], values["MemAvailable"]
+	if total == 0 || available > total {
+		return status.Usage{}, errors.New("read memory usage: /proc/meminfo has no MemTotal or MemAvailable")
+	}
+	return status.Usage{Used: total - available, Total: total}, nil
+}
+
+// pendingUpgrades counts the packages a simulated `apt-get upgrade` would upgrade.
+func pendingUpgrades(ctx context.Context) (int, error) {
+	out, err := exec.CommandContext(ctx, "apt-get", "-s", "-q", "upgrade").Output()
+	if err != nil {
+		return 0, fmt.Errorf("simulate apt-get upgrade: %w", err)
+	}
+	count := 0
+	for line := range bytes.SplitSeq(out, []byte("\n")) {
+		if bytes.HasPrefix(line, []byte("Inst ")) {
+			count++
+		}
+	}
+	return count, nil
+}
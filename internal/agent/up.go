@@ -3,26 +3,57 @@ package agent
 import (
 	"context"
 	_ "embed"
+	"fmt"
+	"io"
+	"os"
+	"slices"
 	"strconv"
 	"strings"
 
 	"github.com/markusylisiurunen/ship/internal/constant"
 	"github.com/markusylisiurunen/ship/internal/reconcile"
+	"github.com/markusylisiurunen/ship/internal/spec"
 	"github.com/urfave/cli/v3"
 )
 
 //go:embed script/install_docker.sh
 var installDockerSh string
 
+//go:embed script/check_docker.sh
+var checkDockerSh string
+
 //go:embed script/setup_fail2ban.sh
 var setupFail2banSh string
 
+//go:embed script/check_fail2ban.sh
+var checkFail2banSh string
+
 //go:embed script/setup_fzf.sh
 var setupFzfSh string
 
+//go:embed script/check_fzf.sh
+var checkFzfSh string
+
 //go:embed script/setup_sshd_config.sh
 var setupSshdConfigSh string
 
+//go:embed script/check_sshd_config.sh
+var checkSshdConfigSh string
+
+//go:embed script/setup_ship_secrets.sh
+var setupShipSecretsSh string
+
+//go:embed script/check_ship_secrets.sh
+var checkShipSecretsSh string
+
+// upStep is a reconciler that `up` runs. Steps are named so that they can be selected with --only and --skip, and
+// list the steps that have to run before them in requires.
+type upStep struct {
+	name       string
+	requires   []string
+	reconciler reconcile.Reconciler
+}
+
 type UpAction struct {
 	version string
 }
@@ -31,65 +62,263 @@ func NewUpAction(version string) *UpAction {
 	return &UpAction{version: version}
 }
 
+// upStepNames are the names of all the steps in the order they run in, including the ones a spec can leave out.
+var upStepNames = []string{"packages", "snaps", "ufw", "sshd", "fail2ban", "fzf", "docker", "secrets", "caddy", "node"}
+
+// basePackages are the apt packages the machine needs regardless of its spec.
+var basePackages = []string{"ca-certificates", "curl", "fail2ban", "git", "jq", "ufw"}
+
 func (a *UpAction) Action(ctx context.Context, cmd *cli.Command) error {
-	steps := []reconcile.Reconciler{}
+	if cmd.Bool("plan") && cmd.Bool("check") {
+		return fmt.Errorf("--plan and --check cannot be used together")
+	}
+
+	// Read the machine spec, falling back to the default profile
+	m := spec.Default()
+	if path := cmd.String("spec"); path != "" {
+		var (
+			data []byte
+			err  error
+		)
+		if path == "-" {
+			data, err = io.ReadAll(os.Stdin)
+		} else {
+			data, err = os.ReadFile(path)
+		}
+		if err != nil {
+			return fmt.Errorf("read machine spec: %w", err)
+		}
+		if m, err = spec.Parse(data); err != nil {
+			return fmt.Errorf("machine spec: %w", err)
+		}
+	}
+
+	steps, err := a.steps(m)
+	if err != nil {
+		return err
+	}
+	if steps, err = selectSteps(steps, cmd.StringSlice("only"), cmd.StringSlice("skip")); err != nil {
+		return err
+	}
+
+	// Only report what would change if planning, or what has drifted if checking
+	if cmd.Bool("plan") {
+		return a.plan(ctx, steps)
+	}
+	if cmd.Bool("check") {
+		return a.check(ctx, steps)
+	}
+
+	// Execute all the steps in order
+	warnUnmetRequirements(steps)
+	for _, step := range steps {
+		if err := step.reconciler.Reconcile(ctx); err != nil {
+			return fmt.Errorf("reconcile %s: %w", step.name, err)
+		}
+	}
+
+	return nil
+}
+
+// steps returns the steps that bring the machine to its spec, in the order they have to run in.
+func (a *UpAction) steps(m *spec.Machine) ([]upStep, error) {
+	self, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("locate agent binary: %w", err)
+	}
+
+	packages := slices.Clone(basePackages)
+	if len(m.Snap.Packages) > 0 {
+		packages = append(packages, "snapd")
+	}
+	for _, p := range m.Apt.Packages {
+		if !slices.Contains(packages, p) {
+			packages = append(packages, p)
+		}
+	}
+
+	steps := []upStep{}
 	// Install a set of packages on the system
-	steps = append(steps, &reconcile.AptGet{
-		Upgrade: true,
-		Packages: []string{
-			"ca-certificates",
-			"curl",
-			"fail2ban",
-			"git",
-			"jq",
-			"ripgrep",
-			"snapd",
-			"tree",
-			"ufw",
-			"unzip",
+	steps = append(steps, upStep{
+		name: "packages",
+		reconciler: &reconcile.AptGet{
+			Upgrade:  true,
+			Packages: packages,
 		},
 	})
-	// Install the `btop` and `dust` from `snap`
-	steps = append(steps, &reconcile.RawScript{
-		Script: "snap install btop && snap install dust",
-	})
-	// Setup `ufw` firewall with some basic rules (allowing only SSH, HTTP, HTTPS)
-	steps = append(steps, &reconcile.Ufw{
-		AllowedTcpPorts: []int{constant.SSH.Port, 80, 443},
+	// Install the snap packages, e.g. `btop` and `dust`
+	if len(m.Snap.Packages) > 0 {
+		installs := make([]string, 0, len(m.Snap.Packages))
+		checks := make([]string, 0, len(m.Snap.Packages))
+		for _, p := range m.Snap.Packages {
+			installs = append(installs, "snap install "+p)
+			checks = append(checks, fmt.Sprintf("snap list %[1]s >/dev/null 2>&1 || echo 'snap %[1]s is not installed'", p))
+		}
+		steps = append(steps, upStep{
+			name:     "snaps",
+			requires: []string{"packages"},
+			reconciler: &reconcile.RawScript{
+				Script:      strings.Join(installs, " && "),
+				CheckScript: strings.Join(checks, "\n"),
+			},
+		})
+	}
+	// Setup `ufw` firewall, allowing only SSH, HTTP, HTTPS and the ports of the spec
+	steps = append(steps, upStep{
+		name:     "ufw",
+		requires: []string{"packages"},
+		reconciler: &reconcile.Ufw{
+			AllowedTcpPorts: append([]int{constant.SSH.Port, 80, 443}, m.Ufw.AllowedTCPPorts...),
+		},
 	})
 	// Setup the SSH daemon configuration for better security
-	steps = append(steps, &reconcile.RawScript{
-		Script: strings.ReplaceAll(setupSshdConfigSh, "{{PORT}}", strconv.Itoa(constant.SSH.Port)),
+	steps = append(steps, upStep{
+		name: "sshd",
+		reconciler: &reconcile.RawScript{
+			Script:      strings.ReplaceAll(setupSshdConfigSh, "{{PORT}}", strconv.Itoa(constant.SSH.Port)),
+			CheckScript: checkSshdConfigSh,
+		},
 	})
 	// Setup `fail2ban` to protect against brute-force attacks
-	steps = append(steps, &reconcile.RawScript{
-		Script: setupFail2banSh,
+	steps = append(steps, upStep{
+		name:     "fail2ban",
+		requires: []string{"packages"},
+		reconciler: &reconcile.RawScript{
+			Script:      setupFail2banSh,
+			CheckScript: checkFail2banSh,
+		},
 	})
 	// Install and setup `fzf` command-line fuzzy finder
-	steps = append(steps, &reconcile.RawScript{
-		Script: setupFzfSh,
+	steps = append(steps, upStep{
+		name:     "fzf",
+		requires: []string{"packages"},
+		reconciler: &reconcile.RawScript{
+			Script:      setupFzfSh,
+			CheckScript: checkFzfSh,
+		},
 	})
 	// Install Docker and add the `deploy` user to the `docker` group
-	steps = append(steps, &reconcile.RawScript{
-		Script: installDockerSh,
+	steps = append(steps, upStep{
+		name:     "docker",
+		requires: []string{"packages"},
+		reconciler: &reconcile.RawScript{
+			Script:      installDockerSh,
+			CheckScript: checkDockerSh,
+		},
 	})
-	// Make sure Caddy is installed and running
-	steps = append(steps, &reconcile.Caddy{})
-	// Install Node.js and some global npm packages
-	steps = append(steps, &reconcile.Node{
-		GlobalPackages: []string{
-			"npm@latest",
-			"@openai/codex@latest",
-			"@anthropic-ai/claude-code@latest",
+	// Create the key app secrets are encrypted to, and decrypt them into tmpfs on boot before Docker starts the apps
+	steps = append(steps, upStep{
+		name: "secrets",
+		reconciler: &reconcile.RawScript{
+			Script:      strings.ReplaceAll(setupShipSecretsSh, "{{AGENT}}", self),
+			CheckScript: checkShipSecretsSh,
 		},
 	})
+	// Make sure Caddy is installed and running
+	steps = append(steps, upStep{
+		name:       "caddy",
+		requires:   []string{"packages", "docker"},
+		reconciler: &reconcile.Caddy{},
+	})
+	// Install Node.js and some global npm packages
+	if m.Node.Enabled {
+		steps = append(steps, upStep{
+			name:     "node",
+			requires: []string{"packages"},
+			reconciler: &reconcile.Node{
+				Version:        m.Node.Version,
+				GlobalPackages: m.Node.GlobalPackages,
+			},
+		})
+	}
 
-	// Execute all the steps in order
+	return steps, nil
+}
+
+// selectSteps narrows the steps down to the ones named with --only, if any, and leaves out the ones named with --skip.
+// Steps the spec leaves out can be named too, so that the same flags work for every spec.
+func selectSteps(steps []upStep, only, skip []string) ([]upStep, error) {
+	for _, name := range slices.Concat(only, skip) {
+		if !slices.Contains(upStepNames, name) {
+			return nil, fmt.Errorf("unknown step %q, the steps are: %s", name, strings.Join(upStepNames, ", "))
+		}
+	}
+
+	selected := []upStep{}
+	for _, step := range steps {
+		if (len(only) == 0 || slices.Contains(only, step.name)) && !slices.Contains(skip, step.name) {
+			selected = append(selected, step)
+		}
+	}
+	return selected, nil
+}
+
+// warnUnmetRequirements warns about steps that require a step which is not run. That is allowed, as the required step
+// may well have been set up by an earlier run.
+func warnUnmetRequirements(steps []upStep) {
+	for _, unmet := range unmetRequirements(steps) {
+		fmt.Printf("Warning: step %s requires step %s, which is not run, so it fails unless %s is already set up\n",
+			unmet[0], unmet[1], unmet[1])
+	}
+}
+
+// unmetRequirements returns each step and a step it requires that is not among the steps.
+func unmetRequirements(steps []upStep) [][2]string {
+	var unmet [][2]string
+	for _, step := range steps {
+		for _, required := range step.requires {
+			if !slices.ContainsFunc(steps, func(s upStep) bool { return s.name == required }) {
+				unmet = append(unmet, [2]string{step.name, required})
+			}
+		}
+	}
+	return unmet
+}
+
+// plan prints the changes each step would make, without changing anything.
+func (a *UpAction) plan(ctx context.Context, steps []upStep) error {
+	total := 0
 	for _, step := range steps {
-		if err := step.Reconcile(ctx); err != nil {
-			return err
+		changes, err := step.reconciler.Plan(ctx)
+		if err != nil {
+			return fmt.Errorf("plan %s: %w", step.name, err)
 		}
+		fmt.Printf("%s:\n", step.name)
+		if len(changes) == 0 {
+			fmt.Printf("  no changes\n")
+		}
+		for _, change := range changes {
+			fmt.Printf("  %s\n", change)
+		}
+		total += len(changes)
 	}
+	fmt.Printf("\nPlan: %d change(s), nothing was changed\n", total)
+	return nil
+}
 
+// check prints the drift each step reports and exits with constant.ExitDrift if there is any, without changing
+// anything.
+func (a *UpAction) check(ctx context.Context, steps []upStep) error {
+	total := 0
+	for _, step := range steps {
+		drift, err := step.reconciler.Check(ctx)
+		if err != nil {
+			return fmt.Errorf("check %s: %w", step.name, err)
+		}
+		if len(drift) == 0 {
+			fmt.Printf("%s: ok\n", step.name)
+			continue
+		}
+		fmt.Printf("%s: drifted\n", step.name)
+		for _, change := range drift {
+			fmt.Printf("  %s\n", change)
+		}
+		total += len(drift)
+	}
+	if total > 0 {
+		fmt.Printf("\nCheck: %d difference(s) from the machine spec\n", total)
+		return cli.Exit("", constant.ExitDrift)
+	}
+	fmt.Printf("\nCheck: the machine matches its spec\n")
 	return nil
 }
@@ -0,0 +1,119 @@
+package agent
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/markusylisiurunen/ship/internal/spec"
+)
+
+func TestUpStepNames(t *testing.T) {
+	steps, err := (&UpAction{}).steps(spec.Default())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, step := range steps {
+		names = append(names, step.name)
+	}
+	if !slices.Equal(names, upStepNames) {
+		t.Errorf("steps of the default spec = %q, want all of %q", names, upStepNames)
+	}
+}
+
+func TestSelectSteps(t *testing.T) {
+	// Without snaps and Node.js, the spec a CI machine might use
+	minimal, err := spec.Parse([]byte("[snap]\npackages = []\n[node]\nenabled = false\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name      string
+		spec      *spec.Machine
+		only      []string
+		skip      []string
+		want      []string
+		wantUnmet [][2]string
+		wantErr   string
+	}{
+		{
+			name: "all steps",
+			spec: spec.Default(),
+			want: upStepNames,
+		},
+		{
+			name: "only keeps the order of the steps",
+			spec: spec.Default(),
+			only: []string{"caddy", "packages", "docker"},
+			want: []string{"packages", "docker", "caddy"},
+		},
+		{
+			name:      "only without a requirement",
+			spec:      spec.Default(),
+			only:      []string{"docker"},
+			want:      []string{"docker"},
+			wantUnmet: [][2]string{{"docker", "packages"}},
+		},
+		{
+			name: "skip a requirement",
+			spec: spec.Default(),
+			skip: []string{"packages", "node"},
+			want: []string{"snaps", "ufw", "sshd", "fail2ban", "fzf", "docker", "secrets", "caddy"},
+			wantUnmet: [][2]string{
+				{"snaps", "packages"}, {"ufw", "packages"}, {"fail2ban", "packages"},
+				{"fzf", "packages"}, {"docker", "packages"}, {"caddy", "packages"},
+			},
+		},
+		{
+			name:      "only and skip",
+			spec:      spec.Default(),
+			only:      []string{"docker", "caddy"},
+			skip:      []string{"docker"},
+			want:      []string{"caddy"},
+			wantUnmet: [][2]string{{"caddy", "packages"}, {"caddy", "docker"}},
+		},
+		{
+			name: "skip a step the spec leaves out",
+			spec: minimal,
+			skip: []string{"node"},
+			want: []string{"packages", "ufw", "sshd", "fail2ban", "fzf", "docker", "secrets", "caddy"},
+		},
+		{
+			name: "only steps the spec leaves out",
+			spec: minimal,
+			only: []string{"snaps", "node"},
+			want: []string{},
+		},
+		{name: "unknown only", spec: spec.Default(), only: []string{"nginx"}, wantErr: `unknown step "nginx"`},
+		{name: "unknown skip", spec: minimal, skip: []string{"node", "nginx"}, wantErr: `unknown step "nginx"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			steps, err := (&UpAction{}).steps(tt.spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			selected, err := selectSteps(steps, tt.only, tt.skip)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("selectSteps() error = %v, want error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("selectSteps() error = %v", err)
+			}
+			got := []string{}
+			for _, step := range selected {
+				got = append(got, step.name)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("selectSteps() = %q, want %q", got, tt.want)
+			}
+			if unmet := unmetRequirements(selected); !slices.Equal(unmet, tt.wantUnmet) {
+				t.Errorf("unmetRequirements() = %q, want %q", unmet, tt.wantUnmet)
+			}
+		})
+	}
+}
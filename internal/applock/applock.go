@@ -0,0 +1,45 @@
+package applock
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"time"
+)
+
+// Holder describes the operation holding the lock of an app. The agent records it in the app's lock file.
+type Holder struct {
+	Operation string    `json:"operation"`
+	User      string    `json:"user"`
+	Version   string    `json:"version,omitempty"`
+	PID       int       `json:"pid"`
+	StartedAt time.Time `json:"started_at"`
+}
+
+func (h Holder) String() string {
+	operation := h.Operation
+	if h.Version != "" {
+		operation += " of " + h.Version
+	}
+	return fmt.Sprintf("%s by %s since %s (pid %d)",
+		operation, h.User, h.StartedAt.Local().Format("2006-01-02 15:04:05 MST"), h.PID)
+}
+
+// Status is the lock state of an app that the agent reports as JSON and the client renders.
+type Status struct {
+	Locked bool `json:"locked"`
+	// Holder is nil if the app is not locked or the holder of its lock is not recorded.
+	Holder *Holder `json:"holder,omitempty"`
+}
+
+// LocalUser identifies the local user as user@hostname to anyone who finds an app locked by one of their operations.
+func LocalUser() string {
+	name := "unknown"
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+	if hostname, err := os.Hostname(); err == nil {
+		name += "@" + hostname
+	}
+	return name
+}
@@ -0,0 +1,162 @@
+package bundle
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	TypeFile    = "file"
+	TypeDir     = "dir"
+	TypeSymlink = "symlink"
+)
+
+// blobKeyRegexp matches the blob keys produced by File.BlobKey.
+var blobKeyRegexp = regexp.MustCompile(`^[0-9a-f]{64}-[0-7]{3,4}$`)
+
+// Manifest lists every entry of a release. Regular files are stored on the machine as content-addressed blobs that
+// releases hardlink, so a deploy only has to upload the blobs the machine does not have yet.
+type Manifest struct {
+	Files []File `json:"files"`
+}
+
+type File struct {
+	Path   string      `json:"path"`
+	Type   string      `json:"type"`
+	Mode   fs.FileMode `json:"mode"`
+	Hash   string      `json:"hash,omitempty"`
+	Target string      `json:"target,omitempty"`
+}
+
+// BlobKey identifies the blob holding a regular file. The permissions are part of the key because hardlinks share
+// them.
+func (f File) BlobKey() string {
+	return f.Hash + "-" + strconv.FormatUint(uint64(f.Mode.Perm()), 8)
+}
+
+// ValidBlobKey reports whether s is a well-formed blob key.
+func ValidBlobKey(s string) bool {
+	return blobKeyRegexp.MatchString(s)
+}
+
+// maxSymlinkFollows limits how many symlinks resolving a single symlink may pass through, like the kernel's ELOOP.
+const maxSymlinkFollows = 40
+
+// Validate checks that every entry stays inside the release directory and is of a known type. Symlinks are resolved
+// through every symlink the manifest declares, so that a chain of symlinks cannot point outside either.
+func (m *Manifest) Validate() error {
+	links := map[string]string{}
+	for _, f := range m.Files {
+		if f.Type == TypeSymlink {
+			links[f.Path] = f.Target
+		}
+	}
+	for _, f := range m.Files {
+		if f.Path == "" || path.IsAbs(f.Path) || path.Clean(f.Path) != f.Path || f.Path == "." ||
+			f.Path == ".." || strings.HasPrefix(f.Path, "../") {
+			return fmt.Errorf("path %q escapes the release directory", f.Path)
+		}
+		switch f.Type {
+		case TypeFile:
+			if !ValidBlobKey(f.BlobKey()) {
+				return fmt.Errorf("file %q has an invalid hash", f.Path)
+			}
+		case TypeDir:
+		case TypeSymlink:
+			follows := 0
+			if _, ok := resolvePath(links, nil, f.Path, &follows); f.Target == "" || !ok {
+				return fmt.Errorf("symlink %q points outside the release directory", f.Path)
+			}
+		default:
+			return fmt.Errorf("entry %q has unknown type %q", f.Path, f.Type)
+		}
+	}
+	return nil
+}
+
+// resolvePath resolves p, relative to the already resolved directory dir of the release, one component at a time and
+// through the symlinks in links. It reports false if the path leaves the release directory at any point, is absolute,
+// or follows too many symlinks.
+func resolvePath(links map[string]string, dir []string, p string, follows *int) ([]string, bool) {
+	if path.IsAbs(p) {
+		return nil, false
+	}
+	current := slices.Clone(dir)
+	for _, part := range strings.Split(p, "/") {
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			if len(current) == 0 {
+				return nil, false
+			}
+			current = current[:len(current)-1]
+			continue
+		}
+		target, ok := links[strings.Join(append(slices.Clone(current), part), "/")]
+		if !ok {
+			current = append(current, part)
+			continue
+		}
+		// The target is relative to the symlink's directory, which is resolved already
+		*follows++
+		if *follows > maxSymlinkFollows {
+			return nil, false
+		}
+		if current, ok = resolvePath(links, current, target, follows); !ok {
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+// HashFile returns the hex-encoded SHA-256 of a file's content.
+func HashFile(name string) (string, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return "", fmt.Errorf("open %q: %w", name, err)
+	}
+	defer file.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return "", fmt.Errorf("hash %q: %w", name, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Entry describes a path under root for the manifest, or returns false for files that cannot be part of a release,
+// such as sockets and devices.
+func Entry(root, relPath string, info fs.FileInfo) (File, bool, error) {
+	f := File{Path: filepath.ToSlash(relPath), Mode: info.Mode().Perm()}
+	switch {
+	case info.IsDir():
+		f.Type = TypeDir
+	case info.Mode()&fs.ModeSymlink != 0:
+		target, err := os.Readlink(filepath.Join(root, relPath))
+		if err != nil {
+			return File{}, false, fmt.Errorf("read symlink %q: %w", relPath, err)
+		}
+		f.Type = TypeSymlink
+		f.Target = filepath.ToSlash(target)
+	case info.Mode().IsRegular():
+		hash, err := HashFile(filepath.Join(root, relPath))
+		if err != nil {
+			return File{}, false, err
+		}
+		f.Type = TypeFile
+		f.Hash = hash
+	default:
+		return File{}, false, nil
+	}
+	return f, true, nil
+}
@@ -0,0 +1,101 @@
+package bundle
+
+import (
+	"strings"
+	"testing"
+)
+
+const testHash = "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+
+func dir(p string) File {
+	return File{Path: p, Type: TypeDir, Mode: 0o755}
+}
+
+func symlink(p, target string) File {
+	return File{Path: p, Type: TypeSymlink, Mode: 0o777, Target: target}
+}
+
+func TestManifestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   []File
+		wantErr string
+	}{
+		{
+			name:  "files and directories",
+			files: []File{dir("a"), {Path: "a/b.txt", Type: TypeFile, Mode: 0o644, Hash: testHash}},
+		},
+		{name: "absolute path", files: []File{dir("/etc")}, wantErr: "escapes"},
+		{name: "parent path", files: []File{dir("../a")}, wantErr: "escapes"},
+		{name: "unclean path", files: []File{dir("a/./b")}, wantErr: "escapes"},
+		{name: "dot path", files: []File{dir(".")}, wantErr: "escapes"},
+		{name: "invalid hash", files: []File{{Path: "a", Type: TypeFile, Mode: 0o644, Hash: "abc"}}, wantErr: "hash"},
+		{name: "unknown type", files: []File{{Path: "a", Type: "fifo"}}, wantErr: "unknown type"},
+		{name: "symlink to sibling", files: []File{symlink("a", "b")}},
+		{name: "symlink into subdirectory", files: []File{dir("d"), symlink("l", "d/x")}},
+		{name: "symlink up to root", files: []File{dir("d"), symlink("d/l", "..")}},
+		{name: "symlink up and down", files: []File{dir("d"), dir("e"), symlink("d/l", "../e/f")}},
+		{name: "empty target", files: []File{symlink("a", "")}, wantErr: "outside"},
+		{name: "absolute target", files: []File{symlink("a", "/etc/passwd")}, wantErr: "outside"},
+		{name: "parent target", files: []File{symlink("a", "../x")}, wantErr: "outside"},
+		{name: "parent target from subdirectory", files: []File{dir("d"), symlink("d/l", "../../x")}, wantErr: "outside"},
+		{
+			name:  "chain staying inside",
+			files: []File{dir("d"), dir("d/e"), symlink("d/e/s", ".."), symlink("t", "d/e/s/..")},
+		},
+		{
+			name:    "chain escaping through a symlink to the parent",
+			files:   []File{dir("d"), symlink("d/s", ".."), symlink("t", "d/s/..")},
+			wantErr: "outside",
+		},
+		{
+			name:    "chain escaping through a symlink to the root",
+			files:   []File{dir("d"), dir("d/e"), symlink("r", "."), symlink("d/e/l", "../../r/..")},
+			wantErr: "outside",
+		},
+		{
+			name:    "chain of symlinks to a parent",
+			files:   []File{symlink("a", "b"), symlink("b", "c"), symlink("c", "..")},
+			wantErr: "outside",
+		},
+		{
+			name:    "symlink loop",
+			files:   []File{symlink("a", "b"), symlink("b", "a")},
+			wantErr: "outside",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := (&Manifest{Files: tt.files}).Validate()
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Fatalf("Validate() = %v, want no error", err)
+			case tt.wantErr != "" && err == nil:
+				t.Fatalf("Validate() = nil, want an error containing %q", tt.wantErr)
+			case tt.wantErr != "" && !strings.Contains(err.Error(), tt.wantErr):
+				t.Fatalf("Validate() = %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidBlobKey(t *testing.T) {
+	tests := []struct {
+		key  string
+		want bool
+	}{
+		{key: testHash + "-644", want: true},
+		{key: testHash + "-0755", want: true},
+		{key: testHash, want: false},
+		{key: testHash + "-64", want: false},
+		{key: testHash + "-648", want: false},
+		{key: strings.ToUpper(testHash) + "-644", want: false},
+		{key: "../" + testHash + "-644", want: false},
+		{key: testHash + "-644/x", want: false},
+	}
+	for _, tt := range tests {
+		if got := ValidBlobKey(tt.key); got != tt.want {
+			t.Errorf("ValidBlobKey(%q) = %v, want %v", tt.key, got, tt.want)
+		}
+	}
+}
@@ -1,122 +1,34 @@
 package client
 
 import (
-	"archive/zip"
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
-	"regexp"
-	"time"
+	"slices"
+	"strings"
 
-	"github.com/bramvdbogaerde/go-scp"
-	"github.com/hetznercloud/hcloud-go/v2/hcloud"
-	"github.com/markusylisiurunen/ship/internal/constant"
+	"github.com/markusylisiurunen/ship/internal/applock"
+	"github.com/markusylisiurunen/ship/internal/bundle"
+	"github.com/markusylisiurunen/ship/internal/format"
 	"github.com/urfave/cli/v3"
-	"golang.org/x/crypto/ssh"
 )
 
 type DeployAction struct {
 	version string
-	hetzner *hcloud.Client
-	ssh     *ssh.Client
+	target  *target
 }
 
 func NewDeployAction(version string) *DeployAction {
 	return &DeployAction{version: version}
 }
 
-func (a *DeployAction) init(ctx context.Context, cmd *cli.Command) (cleanup func(), initErr error) {
-	cleanup = func() {
-		if a.ssh != nil {
-			a.ssh.Close()
-		}
-	}
-
-	token := cmd.String("token")
-	if token == "" {
-		initErr = fmt.Errorf("hetzner API token is required")
-		return
-	}
-	a.hetzner = hcloud.NewClient(hcloud.WithToken(token))
-
-	serverName := cmd.String("server-name")
-	if serverName == "" {
-		initErr = fmt.Errorf("server name is required")
-		return
-	}
-	server, _, err := a.hetzner.Server.GetByName(ctx, serverName)
-	if err != nil {
-		initErr = fmt.Errorf("fetch server %q: %w", serverName, err)
-		return
-	}
-	if server == nil {
-		initErr = fmt.Errorf("server %q not found", serverName)
-		return
-	}
-
-	sshPrivateKey := cmd.String("ssh-private-key")
-	if sshPrivateKey == "" {
-		initErr = fmt.Errorf("ssh private key is required")
-		return
-	}
-	privateKey, err := os.ReadFile(sshPrivateKey)
-	if err != nil {
-		initErr = fmt.Errorf("read ssh private key %q: %w", sshPrivateKey, err)
-		return
-	}
-	signer, err := ssh.ParsePrivateKey(privateKey)
-	if err != nil {
-		initErr = fmt.Errorf("parse ssh private key: %w", err)
-		return
-	}
-	if client, err := ssh.Dial(
-		"tcp",
-		fmt.Sprintf("%s:%d", server.PublicNet.IPv4.IP.String(), constant.SSH.Port),
-		&ssh.ClientConfig{
-			Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
-			HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-			Timeout:         10 * time.Second,
-			User:            "deploy",
-		},
-	); err != nil {
-		initErr = fmt.Errorf("connect to server %q over ssh: %w", serverName, err)
-		return
-	} else {
-		a.ssh = client
-	}
-
-	return
-}
-
 func (a *DeployAction) Action(ctx context.Context, cmd *cli.Command) error {
-	// Initialize the Hetzner client and SSH connection
-	cleanupInit, err := a.init(ctx, cmd)
-	if err != nil {
-		return err
-	}
-	defer cleanupInit()
-
-	// Ensure the `agent` binary is on the machine
-	var copyErr error
-	if a.version == "dev" {
-		copyErr = copyDevAgentBinaryToServer(ctx, a.ssh, false)
-	} else {
-		copyErr = copyVersionedAgentBinaryToServer(ctx, a.ssh, false, a.version)
-	}
-	if copyErr != nil {
-		return fmt.Errorf("ensure agent binary on server: %w", copyErr)
-	}
-
-	// Create the archive of the current directory
-	archivePath, cleanupArchive, err := a.createArchive()
-	if err != nil {
-		return fmt.Errorf("create app archive: %w", err)
-	}
-	defer cleanupArchive()
-
-	// Upload the archive to the server
 	var (
 		appName    = cmd.String("app-name")
 		appVersion = cmd.String("app-version")
@@ -124,64 +36,83 @@ func (a *DeployAction) Action(ctx context.Context, cmd *cli.Command) error {
 	if appName == "" || appVersion == "" {
 		return fmt.Errorf("app name and version are required")
 	}
-	alphaNumericRegexp := regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
-	if !alphaNumericRegexp.MatchString(appName) {
-		return fmt.Errorf("app name %q can only contain letters, numbers, dashes, and underscores", appName)
+	if err := validateName("app name", appName); err != nil {
+		return err
 	}
-	if !alphaNumericRegexp.MatchString(appVersion) {
-		return fmt.Errorf("app version %q can only contain letters, numbers, dashes, and underscores", appVersion)
+	if err := validateName("app version", appVersion); err != nil {
+		return err
 	}
-	if err := a.uploadArchive(ctx, archivePath, appName, appVersion); err != nil {
-		return fmt.Errorf("upload archive: %w", err)
+
+	// Connect to the server and ensure the `agent` binary is on it
+	t, err := connectAndEnsureAgent(ctx, cmd, false, a.version)
+	if err != nil {
+		return err
 	}
+	defer t.Close()
+	a.target = t
 
-	// Execute the appropriate `agent` command on the machine
-	sess, err := a.ssh.NewSession()
+	// Describe the files of the current directory
+	m, blobs, err := a.listFiles(cmd.Bool("gitignore"), cmd.StringSlice("allow-sensitive"))
+	if err != nil {
+		return fmt.Errorf("list app files: %w", err)
+	}
+
+	// Ask the server which file contents it does not have yet
+	missing, err := a.missingBlobs(ctx, appName, blobs)
 	if err != nil {
-		return fmt.Errorf("create SSH session for deploy: %w", err)
+		return fmt.Errorf("compare app files with the server: %w", err)
 	}
-	defer sess.Close()
-	sess.Stdout = os.Stdout
-	sess.Stderr = os.Stderr
+
+	// Execute the appropriate `agent` command on the machine
 	deployCmd := fmt.Sprintf("/home/deploy/.ship/%s/agent deploy --app-name %s --app-version %s",
 		a.version, appName, appVersion)
 	if volumeNames := cmd.StringSlice("volume-name"); len(volumeNames) > 0 {
 		for _, volumeName := range volumeNames {
-			if !alphaNumericRegexp.MatchString(volumeName) {
-				return fmt.Errorf("volume name %q can only contain letters, numbers, dashes, and underscores", volumeName)
+			if err := validateName("volume name", volumeName); err != nil {
+				return err
 			}
 			deployCmd += fmt.Sprintf(" --volume-name %s", volumeName)
 		}
 	}
+	if keepReleases := cmd.Int("keep-releases"); keepReleases > 0 {
+		deployCmd += fmt.Sprintf(" --keep-releases %d", keepReleases)
+	}
+	deployCmd += fmt.Sprintf(" --lock-holder %s", shellQuote(applock.LocalUser()))
 	fmt.Printf("Running deploy command: %s\n", deployCmd)
-	if err := sess.Run(deployCmd); err != nil {
-		return fmt.Errorf("run deploy command %q: %w", deployCmd, err)
+	archive, writeErr := a.streamArchive(m, missing, blobs)
+	defer archive.Close()
+	if err := a.target.runWithStdin(ctx, deployCmd, archive); err != nil {
+		return fmt.Errorf("run deploy command: %w", err)
+	}
+	if err := <-writeErr; err != nil {
+		return fmt.Errorf("stream release archive: %w", err)
 	}
 
 	return nil
 }
 
-func (a *DeployAction) createArchive() (string, func(), error) {
-	tempFile, err := os.CreateTemp("", "ship*.zip")
-	if err != nil {
-		return "", nil, fmt.Errorf("create temp file for archive: %w", err)
-	}
-	defer tempFile.Close()
-
-	cleanup := func() {
-		if err := os.Remove(tempFile.Name()); err != nil {
-			fmt.Printf("Failed to remove temp archive file %q: %v\n", tempFile.Name(), err)
-		}
-	}
-
-	zipWriter := zip.NewWriter(tempFile)
-	defer zipWriter.Close()
+// localBlob is a file of the project whose content is stored as a blob on the server.
+type localBlob struct {
+	path string
+	size int64
+}
 
+// listFiles describes the files of the current directory that go into the release, along with the local file holding
+// the content of each blob.
+func (a *DeployAction) listFiles(
+	useGitignore bool, allowSensitive []string,
+) (*bundle.Manifest, map[string]localBlob, error) {
 	cwd, err := os.Getwd()
 	if err != nil {
-		return "", cleanup, fmt.Errorf("determine working directory: %w", err)
+		return nil, nil, fmt.Errorf("determine working directory: %w", err)
+	}
+	filter, err := newArchiveFilter(cwd, useGitignore, allowSensitive)
+	if err != nil {
+		return nil, nil, err
 	}
 
+	m := &bundle.Manifest{}
+	blobs := map[string]localBlob{}
 	walkErr := filepath.Walk(cwd, func(path string, info os.FileInfo, walkErr error) error {
 		if walkErr != nil {
 			return fmt.Errorf("walk into %q: %w", path, walkErr)
@@ -195,87 +126,137 @@ func (a *DeployAction) createArchive() (string, func(), error) {
 			return nil
 		}
 
-		// Skip .git directories
-		if info.IsDir() && info.Name() == ".git" {
-			return filepath.SkipDir
-		}
-
-		// Write the ZIP header
-		header, err := zip.FileInfoHeader(info)
-		if err != nil {
-			return fmt.Errorf("create zip header for %q: %w", relPath, err)
-		}
-		header.Name = relPath
-		if info.IsDir() {
-			header.Name += "/"
-		}
-		header.Method = zip.Deflate
-		writer, err := zipWriter.CreateHeader(header)
-		if err != nil {
-			return fmt.Errorf("create zip entry for %q: %w", relPath, err)
-		}
-
-		// If it's a directory, nothing more to do
-		if info.IsDir() {
+		// Skip .git directories and paths excluded by ignore rules
+		if !filter.include(relPath, info.IsDir()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
 			return nil
 		}
 
-		// Write the file content
-		file, err := os.Open(path)
+		f, ok, err := bundle.Entry(cwd, relPath, info)
 		if err != nil {
-			return fmt.Errorf("open %q: %w", path, err)
+			return err
 		}
-		if _, err := io.Copy(writer, file); err != nil {
-			file.Close()
-			return fmt.Errorf("copy %q into archive: %w", path, err)
+		if !ok {
+			fmt.Printf("Skipping %q, which is not a regular file, directory, or symlink\n", relPath)
+			return nil
 		}
-		if err := file.Close(); err != nil {
-			return fmt.Errorf("close %q after copying: %w", path, err)
+		m.Files = append(m.Files, f)
+		if f.Type == bundle.TypeFile {
+			blobs[f.BlobKey()] = localBlob{path: path, size: info.Size()}
 		}
-
 		return nil
 	})
 	if walkErr != nil {
-		return "", cleanup, fmt.Errorf("walk project directory: %w", walkErr)
+		return nil, nil, fmt.Errorf("walk project directory: %w", walkErr)
+	}
+	filter.printSummary()
+	if err := m.Validate(); err != nil {
+		return nil, nil, err
 	}
 
-	return tempFile.Name(), cleanup, nil
+	return m, blobs, nil
 }
 
-func (a *DeployAction) uploadArchive(
-	ctx context.Context, localArchive, appName, appVersion string,
-) error {
-	// Open the local archive file
-	archiveFile, err := os.Open(localArchive)
+// missingBlobs returns the keys of the blobs the server does not have for the app.
+func (a *DeployAction) missingBlobs(ctx context.Context, appName string, blobs map[string]localBlob) ([]string, error) {
+	var keys bytes.Buffer
+	for key := range blobs {
+		keys.WriteString(key + "\n")
+	}
+	out, err := a.target.outputWithStdin(ctx,
+		fmt.Sprintf("/home/deploy/.ship/%s/agent blobs missing --app-name %s", a.version, appName), &keys)
 	if err != nil {
-		return fmt.Errorf("open archive %q: %w", localArchive, err)
+		return nil, err
 	}
-	defer archiveFile.Close()
+	missing := strings.Fields(string(out))
+	for _, key := range missing {
+		if _, ok := blobs[key]; !ok {
+			return nil, fmt.Errorf("server reported unknown blob %q as missing", key)
+		}
+	}
+	slices.Sort(missing)
+
+	var total, upload int64
+	for key, b := range blobs {
+		total += b.size
+		if slices.Contains(missing, key) {
+			upload += b.size
+		}
+	}
+	fmt.Printf("Uploading %d of %d file contents (%s of %s)\n",
+		len(missing), len(blobs), format.Bytes(upload), format.Bytes(total))
+	return missing, nil
+}
 
-	// Make sure the remote directory exists
-	remoteAppDir := fmt.Sprintf("/home/deploy/apps/%s/%s", appName, appVersion)
-	sess, err := a.ssh.NewSession()
+// streamArchive writes a gzip-compressed tar archive of the file manifest and the given blobs into the returned
+// reader. The returned channel receives the result once the archive is written or the reader is closed.
+func (a *DeployAction) streamArchive(
+	m *bundle.Manifest, keys []string, blobs map[string]localBlob,
+) (io.ReadCloser, <-chan error) {
+	pr, pw := io.Pipe()
+	errc := make(chan error, 1)
+	go func() {
+		err := a.writeArchive(pw, m, keys, blobs)
+		pw.CloseWithError(err)
+		errc <- err
+	}()
+	return pr, errc
+}
+
+func (a *DeployAction) writeArchive(w io.Writer, m *bundle.Manifest, keys []string, blobs map[string]localBlob) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+
+	// Write the file manifest first so the agent can validate it before receiving any content
+	manifestJSON, err := json.Marshal(m)
 	if err != nil {
-		return fmt.Errorf("create SSH session for archive upload: %w", err)
+		return fmt.Errorf("encode file manifest: %w", err)
+	}
+	if err := tw.WriteHeader(&tar.Header{
+		Name: "files.json", Typeflag: tar.TypeReg, Mode: 0o644, Size: int64(len(manifestJSON)),
+	}); err != nil {
+		return fmt.Errorf("write file manifest: %w", err)
 	}
-	defer sess.Close()
-	sess.Stdout = os.Stdout
-	sess.Stderr = os.Stderr
-	mkdirCmd := fmt.Sprintf("mkdir -p %s", remoteAppDir)
-	if err := sess.Run(mkdirCmd); err != nil {
-		return fmt.Errorf("run remote command %q: %w", mkdirCmd, err)
+	if _, err := tw.Write(manifestJSON); err != nil {
+		return fmt.Errorf("write file manifest: %w", err)
 	}
 
-	// Upload the archive to the server
-	remoteArchive := fmt.Sprintf("%s/archive.zip", remoteAppDir)
-	client, err := scp.NewClientBySSH(a.ssh)
-	if err != nil {
-		return fmt.Errorf("create SCP client: %w", err)
+	for _, key := range keys {
+		if err := a.writeBlob(tw, key, blobs[key].path); err != nil {
+			return err
+		}
 	}
-	defer client.Close()
-	if err := client.CopyFromFile(ctx, *archiveFile, remoteArchive, "0755"); err != nil {
-		return fmt.Errorf("copy archive to %q: %w", remoteArchive, err)
+
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("finish archive: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return fmt.Errorf("finish archive: %w", err)
 	}
+	return nil
+}
 
+func (a *DeployAction) writeBlob(tw *tar.Writer, key, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("open %q: %w", path, err)
+	}
+	defer file.Close()
+	// The size is taken from the open file, in case the file changed since it was hashed; the agent then rejects the
+	// blob because its content no longer matches the key
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("stat %q: %w", path, err)
+	}
+	if err := tw.WriteHeader(&tar.Header{
+		Name: "blobs/" + key, Typeflag: tar.TypeReg, Mode: 0o644, Size: info.Size(),
+	}); err != nil {
+		return fmt.Errorf("write archive entry for %q: %w", path, err)
+	}
+	if _, err := io.CopyN(tw, file, info.Size()); err != nil {
+		return fmt.Errorf("copy %q into archive: %w", path, err)
+	}
 	return nil
 }
@@ -0,0 +1,99 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/urfave/cli/v3"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/term"
+)
+
+// shellCommand starts bash in the container if it has one, and sh otherwise.
+var shellCommand = []string{"sh", "-c", "if command -v bash >/dev/null 2>&1; then exec bash; else exec sh; fi"}
+
+type ExecAction struct {
+	version string
+}
+
+func NewExecAction(version string) *ExecAction {
+	return &ExecAction{version: version}
+}
+
+func (a *ExecAction) Action(ctx context.Context, cmd *cli.Command) error {
+	command := cmd.Args().Slice()
+	if len(command) == 0 {
+		return fmt.Errorf("command is required, e.g. ship exec --service web -- npm run migrate")
+	}
+	return execInContainer(ctx, cmd, a.version, command)
+}
+
+type ShellAction struct {
+	version string
+}
+
+func NewShellAction(version string) *ShellAction {
+	return &ShellAction{version: version}
+}
+
+func (a *ShellAction) Action(ctx context.Context, cmd *cli.Command) error {
+	command := shellCommand
+	if shell := cmd.String("shell"); shell != "" {
+		command = []string{shell}
+	}
+	return execInContainer(ctx, cmd, a.version, command)
+}
+
+// execInContainer runs a command in a running container of the app's current release. The command gets a TTY when
+// the client runs in a terminal, and its exit status becomes the client's.
+func execInContainer(ctx context.Context, cmd *cli.Command, version string, command []string) error {
+	var (
+		appName = cmd.String("app-name")
+		service = cmd.String("service")
+	)
+	if err := validateName("app name", appName); err != nil {
+		return err
+	}
+	if !serviceNameRegexp.MatchString(service) {
+		return fmt.Errorf("service name %q is invalid", service)
+	}
+	tty := term.IsTerminal(int(os.Stdin.Fd())) && term.IsTerminal(int(os.Stdout.Fd()))
+
+	// Connect to the server and ensure the `agent` binary is on it
+	t, err := connectAndEnsureAgent(ctx, cmd, false, version)
+	if err != nil {
+		return err
+	}
+	defer t.Close()
+
+	// Execute the appropriate `agent` command on the machine. Without a TTY, Ctrl-C stops the command on the machine
+	// through the SSH session, while with one it is sent to the command as a key press.
+	var execCmd strings.Builder
+	fmt.Fprintf(&execCmd, "/home/deploy/.ship/%s/agent exec --app-name %s --service %s", version, appName, service)
+	if !tty {
+		execCmd.WriteString(" --no-tty")
+	}
+	execCmd.WriteString(" --")
+	for _, arg := range command {
+		execCmd.WriteString(" " + shellQuote(arg))
+	}
+	if !tty {
+		var stop context.CancelFunc
+		ctx, stop = signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+		defer stop()
+	}
+	if err := t.runInteractive(ctx, execCmd.String(), tty); err != nil {
+		var exitErr *ssh.ExitError
+		if errors.As(err, &exitErr) {
+			return cli.Exit("", exitErr.ExitStatus())
+		}
+		return err
+	}
+
+	return nil
+}
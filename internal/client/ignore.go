@@ -0,0 +1,109 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	ignore "github.com/sabhiram/go-gitignore"
+)
+
+// shipIgnoreFile lists the paths, in gitignore syntax, that are left out of the app archive.
+const shipIgnoreFile = ".shipignore"
+
+// sensitivePatterns match files that likely hold credentials. They are left out of the app archive even when no ignore
+// file mentions them, unless explicitly allowed.
+var sensitivePatterns = []string{
+	".env",
+	".env.*",
+	"!.env.example",
+	"!.env.sample",
+	"*.pem",
+	"*.key",
+	"*.p12",
+	"*.pfx",
+	"id_rsa",
+	"id_ecdsa",
+	"id_ed25519",
+	".netrc",
+	".npmrc",
+	".pypirc",
+}
+
+// archiveFilter decides which paths of the project directory go into the app archive, and keeps track of what it
+// left out.
+type archiveFilter struct {
+	ignores   []*ignore.GitIgnore
+	sensitive *ignore.GitIgnore
+	allowed   *ignore.GitIgnore
+	excluded  []string
+	withheld  []string
+}
+
+// newArchiveFilter reads the .shipignore file, and the .gitignore file if asked to, from the project root. Sensitive
+// files matching one of the allowed patterns are archived like any other file.
+func newArchiveFilter(root string, useGitignore bool, allowSensitive []string) (*archiveFilter, error) {
+	f := &archiveFilter{
+		sensitive: ignore.CompileIgnoreLines(sensitivePatterns...),
+		allowed:   ignore.CompileIgnoreLines(allowSensitive...),
+	}
+	files := []string{shipIgnoreFile}
+	if useGitignore {
+		files = append(files, ".gitignore")
+	}
+	for _, name := range files {
+		gi, err := ignore.CompileIgnoreFile(filepath.Join(root, name))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("read %s: %w", name, err)
+		}
+		f.ignores = append(f.ignores, gi)
+	}
+	return f, nil
+}
+
+// include reports whether a path relative to the project root goes into the archive. Excluded directories should be
+// skipped entirely.
+func (f *archiveFilter) include(relPath string, isDir bool) bool {
+	relPath = filepath.ToSlash(relPath)
+	if isDir && filepath.Base(relPath) == ".git" {
+		return false
+	}
+	match := relPath
+	if isDir {
+		match += "/"
+	}
+	for _, gi := range f.ignores {
+		if gi.MatchesPath(match) {
+			f.excluded = append(f.excluded, match)
+			return false
+		}
+	}
+	if !isDir && f.sensitive.MatchesPath(relPath) && !f.allowed.MatchesPath(relPath) {
+		f.withheld = append(f.withheld, relPath)
+		return false
+	}
+	return true
+}
+
+// printSummary prints what was left out of the archive.
+func (f *archiveFilter) printSummary() {
+	const maxListed = 10
+	if len(f.excluded) > 0 {
+		fmt.Printf("Excluded %d path(s) from the archive by ignore rules:\n", len(f.excluded))
+		for i, p := range f.excluded {
+			if i == maxListed {
+				fmt.Printf("  ... and %d more\n", len(f.excluded)-maxListed)
+				break
+			}
+			fmt.Printf("  %s\n", p)
+		}
+	}
+	if len(f.withheld) > 0 {
+		fmt.Printf("Withheld %d sensitive file(s) from the archive: %s\n", len(f.withheld), strings.Join(f.withheld, ", "))
+		fmt.Printf("  Add them to %s to silence this, or pass --allow-sensitive to ship them\n", shipIgnoreFile)
+	}
+}
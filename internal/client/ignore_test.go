@@ -0,0 +1,103 @@
+package client
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestArchiveFilter(t *testing.T) {
+	tests := []struct {
+		name           string
+		shipignore     string
+		gitignore      string
+		useGitignore   bool
+		allowSensitive []string
+		path           string
+		isDir          bool
+		want           bool
+		wantExcluded   []string
+		wantWithheld   []string
+	}{
+		{name: "regular file", path: "main.go", want: true},
+		{name: "git directory", path: ".git", isDir: true, want: false},
+		{name: "nested git directory", path: "vendor/x/.git", isDir: true, want: false},
+		{name: "env file", path: ".env", want: false, wantWithheld: []string{".env"}},
+		{name: "env file variant", path: "app/.env.production", want: false, wantWithheld: []string{"app/.env.production"}},
+		{name: "env example", path: ".env.example", want: true},
+		{name: "env sample", path: "app/.env.sample", want: true},
+		{name: "private key", path: "certs/server.key", want: false, wantWithheld: []string{"certs/server.key"}},
+		{name: "pem file", path: "tls.pem", want: false, wantWithheld: []string{"tls.pem"}},
+		{name: "ssh key", path: "deploy/id_ed25519", want: false, wantWithheld: []string{"deploy/id_ed25519"}},
+		{name: "npmrc", path: ".npmrc", want: false, wantWithheld: []string{".npmrc"}},
+		{name: "sensitive directory name is not withheld", path: "config.key", isDir: true, want: true},
+		{name: "allowed sensitive file", allowSensitive: []string{"certs/*.pem"}, path: "certs/ca.pem", want: true},
+		{
+			name:           "allow pattern does not cover other files",
+			allowSensitive: []string{"certs/*.pem"},
+			path:           "tls.pem",
+			want:           false,
+			wantWithheld:   []string{"tls.pem"},
+		},
+		{
+			name:         "shipignore file",
+			shipignore:   "*.log\n",
+			path:         "logs/app.log",
+			want:         false,
+			wantExcluded: []string{"logs/app.log"},
+		},
+		{
+			name:         "shipignore directory",
+			shipignore:   "node_modules/\n",
+			path:         "node_modules",
+			isDir:        true,
+			want:         false,
+			wantExcluded: []string{"node_modules/"},
+		},
+		{name: "shipignore negation", shipignore: "*.log\n!keep.log\n", path: "keep.log", want: true},
+		{
+			name:         "ignored sensitive file is excluded, not withheld",
+			shipignore:   ".env\n",
+			path:         ".env",
+			want:         false,
+			wantExcluded: []string{".env"},
+		},
+		{name: "gitignore not used", gitignore: "dist/\n", path: "dist", isDir: true, want: true},
+		{
+			name:         "gitignore used",
+			gitignore:    "dist/\n",
+			useGitignore: true,
+			path:         "dist",
+			isDir:        true,
+			want:         false,
+			wantExcluded: []string{"dist/"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			for name, content := range map[string]string{shipIgnoreFile: tt.shipignore, ".gitignore": tt.gitignore} {
+				if content == "" {
+					continue
+				}
+				if err := os.WriteFile(filepath.Join(root, name), []byte(content), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			f, err := newArchiveFilter(root, tt.useGitignore, tt.allowSensitive)
+			if err != nil {
+				t.Fatalf("newArchiveFilter() error = %v", err)
+			}
+			if got := f.include(tt.path, tt.isDir); got != tt.want {
+				t.Errorf("include(%q, %t) = %t, want %t", tt.path, tt.isDir, got, tt.want)
+			}
+			if !slices.Equal(f.excluded, tt.wantExcluded) {
+				t.Errorf("excluded = %q, want %q", f.excluded, tt.wantExcluded)
+			}
+			if !slices.Equal(f.withheld, tt.wantWithheld) {
+				t.Errorf("withheld = %q, want %q", f.withheld, tt.wantWithheld)
+			}
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package client
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// knownHosts is the ship-managed known_hosts file used to pin server host keys.
+type knownHosts struct {
+	path string
+}
+
+// openKnownHosts opens the ship-managed known_hosts file, creating it if it does not exist.
+func openKnownHosts() (*knownHosts, error) {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return nil, fmt.Errorf("determine config directory: %w", err)
+	}
+	path := filepath.Join(configDir, "ship", "known_hosts")
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, fmt.Errorf("create directory for %s: %w", path, err)
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("open %s: %w", path, err)
+	}
+	if err := f.Close(); err != nil {
+		return nil, fmt.Errorf("close %s: %w", path, err)
+	}
+	return &knownHosts{path: path}, nil
+}
+
+// callback returns a host key callback that pins the host key on first use and verifies it on every later connection.
+func (k *knownHosts) callback(serverName string) ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		verify, err := knownhosts.New(k.path)
+		if err != nil {
+			return fmt.Errorf("read %s: %w", k.path, err)
+		}
+		err = verify(hostname, remote, key)
+		var keyErr *knownhosts.KeyError
+		switch {
+		case err == nil:
+			return nil
+		case errors.As(err, &keyErr) && len(keyErr.Want) == 0:
+			fmt.Printf("Pinning host key for server %q (%s): %s\n", serverName, hostname, ssh.FingerprintSHA256(key))
+			return k.add(hostname, key)
+		case errors.As(err, &keyErr):
+			want := make([]string, 0, len(keyErr.Want))
+			for _, w := range keyErr.Want {
+				want = append(want, ssh.FingerprintSHA256(w.Key))
+			}
+			return fmt.Errorf(
+				"host key mismatch for server %q (%s): got %s, pinned %s in %s; "+
+					"if the server was rebuilt, re-pin it with `ship machine trust --server-name %s`",
+				serverName, hostname, ssh.FingerprintSHA256(key), strings.Join(want, ", "), k.path, serverName,
+			)
+		default:
+			return fmt.Errorf("verify host key for server %q (%s): %w", serverName, hostname, err)
+		}
+	}
+}
+
+// algorithms returns the host key algorithms matching the keys pinned for the address, so that the server presents a
+// key of a pinned type. It returns nil when nothing is pinned for the address.
+func (k *knownHosts) algorithms(address string) []string {
+	verify, err := knownhosts.New(k.path)
+	if err != nil {
+		return nil
+	}
+	// Verifying a key that cannot be pinned makes the callback report every pinned key for the address
+	probe, err := ssh.NewPublicKey(ed25519.PublicKey(make([]byte, ed25519.PublicKeySize)))
+	if err != nil {
+		return nil
+	}
+	var keyErr *knownhosts.KeyError
+	if err := verify(address, &net.TCPAddr{}, probe); !errors.As(err, &keyErr) {
+		return nil
+	}
+	var algorithms []string
+	for _, w := range keyErr.Want {
+		switch w.Key.Type() {
+		case ssh.KeyAlgoRSA:
+			algorithms = append(algorithms, ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA)
+		default:
+			algorithms = append(algorithms, w.Key.Type())
+		}
+	}
+	return algorithms
+}
+
+// add appends a host key for the address to the known_hosts file.
+func (k *knownHosts) add(address string, key ssh.PublicKey) error {
+	f, err := os.OpenFile(k.path, os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("open %s: %w", k.path, err)
+	}
+	defer f.Close()
+	line := knownhosts.Line([]string{knownhosts.Normalize(address)}, key)
+	if _, err := fmt.Fprintln(f, line); err != nil {
+		return fmt.Errorf("write %s: %w", k.path, err)
+	}
+	return nil
+}
+
+// replace removes every host key pinned for the address and pins the given key instead.
+func (k *knownHosts) replace(address string, key ssh.PublicKey) error {
+	return k.rewrite(address, key)
+}
+
+// remove removes every host key pinned for the address, so that the next connection pins the key it is presented.
+func (k *knownHosts) remove(address string) error {
+	return k.rewrite(address, nil)
+}
+
+// rewrite removes every host key pinned for the address and pins the given key instead, if any.
+func (k *knownHosts) rewrite(address string, key ssh.PublicKey) error {
+	data, err := os.ReadFile(k.path)
+	if err != nil {
+		return fmt.Errorf("read %s: %w", k.path, err)
+	}
+	normalized := knownhosts.Normalize(address)
+	var kept []string
+	for line := range strings.SplitSeq(strings.TrimRight(string(data), "\n"), "\n") {
+		if fields := strings.Fields(line); len(fields) > 0 && fields[0] == normalized {
+			continue
+		}
+		if line != "" {
+			kept = append(kept, line)
+		}
+	}
+	if key != nil {
+		kept = append(kept, knownhosts.Line([]string{normalized}, key))
+	}
+	var content string
+	if len(kept) > 0 {
+		content = strings.Join(kept, "\n") + "\n"
+	}
+	tmp := k.path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(content), 0o600); err != nil {
+		return fmt.Errorf("write %s: %w", tmp, err)
+	}
+	if err := os.Rename(tmp, k.path); err != nil {
+		return fmt.Errorf("replace %s: %w", k.path, err)
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package client
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func testHostKey(t *testing.T, seed byte) ssh.PublicKey {
+	t.Helper()
+	key, err := ssh.NewPublicKey(ed25519.NewKeyFromSeed(bytes.Repeat([]byte{seed}, ed25519.SeedSize)).Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestKnownHostsRewrite(t *testing.T) {
+	var (
+		oldKey      = testHostKey(t, 1)
+		newKey      = testHostKey(t, 2)
+		otherKey    = testHostKey(t, 3)
+		pinned      = knownhosts.Line([]string{"[10.0.0.1]:2222"}, oldKey)
+		other       = knownhosts.Line([]string{"[10.0.0.2]:2222"}, otherKey)
+		defaultPort = knownhosts.Line([]string{"10.0.0.1"}, otherKey)
+	)
+	tests := []struct {
+		name    string
+		content string
+		address string
+		key     ssh.PublicKey
+		want    []string
+	}{
+		{name: "empty file", content: "", address: "10.0.0.1:2222", want: nil},
+		{name: "empty file with key", content: "", address: "10.0.0.1:2222", key: newKey, want: []string{
+			knownhosts.Line([]string{"[10.0.0.1]:2222"}, newKey),
+		}},
+		{
+			name:    "remove keeps other hosts",
+			content: pinned + "\n" + other + "\n",
+			address: "10.0.0.1:2222",
+			want:    []string{other},
+		},
+		{
+			name:    "remove every key of the address",
+			content: pinned + "\n" + other + "\n" + knownhosts.Line([]string{"[10.0.0.1]:2222"}, newKey) + "\n",
+			address: "10.0.0.1:2222",
+			want:    []string{other},
+		},
+		{
+			name:    "replace",
+			content: pinned + "\n" + other + "\n",
+			address: "10.0.0.1:2222",
+			key:     newKey,
+			want:    []string{other, knownhosts.Line([]string{"[10.0.0.1]:2222"}, newKey)},
+		},
+		{
+			name:    "other port of the same host is kept",
+			content: pinned + "\n" + defaultPort + "\n",
+			address: "10.0.0.1:2222",
+			want:    []string{defaultPort},
+		},
+		{
+			name:    "default port is normalized",
+			content: pinned + "\n" + defaultPort + "\n",
+			address: "10.0.0.1:22",
+			want:    []string{pinned},
+		},
+		{
+			name:    "blank lines are dropped",
+			content: "\n" + other + "\n\n",
+			address: "10.0.0.1:2222",
+			want:    []string{other},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := &knownHosts{path: filepath.Join(t.TempDir(), "known_hosts")}
+			if err := os.WriteFile(k.path, []byte(tt.content), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			if err := k.rewrite(tt.address, tt.key); err != nil {
+				t.Fatalf("rewrite() error = %v", err)
+			}
+			data, err := os.ReadFile(k.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var want string
+			if len(tt.want) > 0 {
+				want = strings.Join(tt.want, "\n") + "\n"
+			}
+			if string(data) != want {
+				t.Errorf("rewrite() wrote %q, want %q", data, want)
+			}
+			if tt.key != nil {
+				verify, err := knownhosts.New(k.path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := verify(tt.address, &net.TCPAddr{}, tt.key); err != nil {
+					t.Errorf("pinned key does not verify: %v", err)
+				}
+			}
+		})
+	}
+}
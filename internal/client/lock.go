@@ -0,0 +1,87 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/markusylisiurunen/ship/internal/applock"
+	"github.com/urfave/cli/v3"
+)
+
+type LockStatusAction struct {
+	version string
+	target  *target
+}
+
+func NewLockStatusAction(version string) *LockStatusAction {
+	return &LockStatusAction{version: version}
+}
+
+func (a *LockStatusAction) Action(ctx context.Context, cmd *cli.Command) error {
+	appName := cmd.String("app-name")
+	if err := validateName("app name", appName); err != nil {
+		return err
+	}
+
+	// Connect to the server and ensure the `agent` binary is on it
+	t, err := connectAndEnsureAgent(ctx, cmd, false, a.version)
+	if err != nil {
+		return err
+	}
+	defer t.Close()
+	a.target = t
+
+	// Execute the appropriate `agent` command on the machine
+	statusCmd := fmt.Sprintf("/home/deploy/.ship/%s/agent lock status --app-name %s", a.version, appName)
+	out, err := a.target.output(ctx, statusCmd)
+	if err != nil {
+		return fmt.Errorf("run lock status command: %w", err)
+	}
+	var s applock.Status
+	if err := json.Unmarshal(out, &s); err != nil {
+		return fmt.Errorf("parse lock status: %w", err)
+	}
+	switch {
+	case !s.Locked:
+		fmt.Printf("App %q is not locked\n", appName)
+	case s.Holder == nil:
+		fmt.Printf("App %q is locked by an unknown operation\n", appName)
+	default:
+		fmt.Printf("App %q is locked: %s\n", appName, s.Holder)
+	}
+
+	return nil
+}
+
+type LockBreakAction struct {
+	version string
+	target  *target
+}
+
+func NewLockBreakAction(version string) *LockBreakAction {
+	return &LockBreakAction{version: version}
+}
+
+func (a *LockBreakAction) Action(ctx context.Context, cmd *cli.Command) error {
+	appName := cmd.String("app-name")
+	if err := validateName("app name", appName); err != nil {
+		return err
+	}
+
+	// Connect to the server and ensure the `agent` binary is on it
+	t, err := connectAndEnsureAgent(ctx, cmd, false, a.version)
+	if err != nil {
+		return err
+	}
+	defer t.Close()
+	a.target = t
+
+	// Execute the appropriate `agent` command on the machine
+	breakCmd := fmt.Sprintf("/home/deploy/.ship/%s/agent lock break --app-name %s", a.version, appName)
+	if err := a.target.run(ctx, breakCmd); err != nil {
+		return fmt.Errorf("run lock break command: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,63 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/urfave/cli/v3"
+)
+
+type LogsAction struct {
+	version string
+	target  *target
+}
+
+func NewLogsAction(version string) *LogsAction {
+	return &LogsAction{version: version}
+}
+
+func (a *LogsAction) Action(ctx context.Context, cmd *cli.Command) error {
+	appName := cmd.String("app-name")
+	if err := validateName("app name", appName); err != nil {
+		return err
+	}
+
+	// Connect to the server and ensure the `agent` binary is on it
+	t, err := connectAndEnsureAgent(ctx, cmd, false, a.version)
+	if err != nil {
+		return err
+	}
+	defer t.Close()
+	a.target = t
+
+	// Execute the appropriate `agent` command on the machine until it ends or Ctrl-C stops it, which also stops it on
+	// the machine
+	var logsCmd strings.Builder
+	fmt.Fprintf(&logsCmd, "/home/deploy/.ship/%s/agent logs --app-name %s", a.version, appName)
+	for _, service := range cmd.StringSlice("service") {
+		fmt.Fprintf(&logsCmd, " --service %s", shellQuote(service))
+	}
+	if cmd.Bool("follow") {
+		logsCmd.WriteString(" --follow")
+	}
+	if since := cmd.String("since"); since != "" {
+		fmt.Fprintf(&logsCmd, " --since %s", shellQuote(since))
+	}
+	if tail := cmd.String("tail"); tail != "" {
+		fmt.Fprintf(&logsCmd, " --tail %s", shellQuote(tail))
+	}
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := a.target.run(ctx, logsCmd.String()); err != nil {
+		if ctx.Err() != nil {
+			return nil
+		}
+		return fmt.Errorf("run logs command: %w", err)
+	}
+
+	return nil
+}
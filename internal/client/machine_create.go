@@ -7,45 +7,32 @@ import (
 	"strings"
 	"time"
 
-	"github.com/hetznercloud/hcloud-go/v2/hcloud"
 	"github.com/markusylisiurunen/ship/internal/constant"
+	"github.com/markusylisiurunen/ship/internal/provider"
 	"github.com/urfave/cli/v3"
 )
 
 type MachineCreateAction struct {
-	version string
-	hetzner *hcloud.Client
+	version  string
+	provider provider.Provider
 }
 
 func NewMachineCreateAction(version string) *MachineCreateAction {
 	return &MachineCreateAction{version: version}
 }
 
-func (a *MachineCreateAction) init(_ context.Context, cmd *cli.Command) (cleanup func(), initErr error) {
-	cleanup = func() {}
-
-	token := cmd.String("token")
-	if token == "" {
-		initErr = fmt.Errorf("hetzner API token is required")
-		return
-	}
-	a.hetzner = hcloud.NewClient(hcloud.WithToken(token))
-
-	return
-}
-
 func (a *MachineCreateAction) Action(ctx context.Context, cmd *cli.Command) error {
-	// Initialize the Hetzner client
-	cleanup, err := a.init(ctx, cmd)
+	// Initialize the provider
+	p, err := newProvider(cmd)
 	if err != nil {
 		return err
 	}
-	defer cleanup()
+	a.provider = p
 
-	// Create the server on Hetzner
+	// Create the server on the provider
 	var (
 		sshKeyName = cmd.String("ssh-key-name")
-		serverName = cmd.String("name")
+		serverName = cmd.String("server-name")
 		serverSize = cmd.String("size")
 		location   = cmd.String("location")
 	)
@@ -55,52 +42,43 @@ func (a *MachineCreateAction) Action(ctx context.Context, cmd *cli.Command) erro
 	if sshKeyName == "" || serverName == "" || serverSize == "" || location == "" {
 		return fmt.Errorf("ssh key name, server name, server size, and location are required")
 	}
-	if err := a.createServer(ctx, sshKeyName, serverName, serverSize, location); err != nil {
+	// The key is needed to wait for the server, and the spec to reconcile it, so check them before paying for one
+	if _, err := loadSigner(cmd); err != nil {
+		return err
+	}
+	if cmd.Bool("up") {
+		if _, err := loadMachineSpec(cmd); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("Creating server %q...\n", serverName)
+	if _, err := a.provider.Create(ctx, provider.CreateOpts{
+		Name:       serverName,
+		SSHKeyName: sshKeyName,
+		Size:       serverSize,
+		Location:   location,
+		UserData:   strings.ReplaceAll(userData, "{{PORT}}", strconv.Itoa(constant.SSH.Port)),
+	}); err != nil {
 		return fmt.Errorf("create server: %w", err)
 	}
 
-	// Wait for the server to be running on Hetzner
-	if err := a.waitForServer(ctx, serverName); err != nil {
+	// Wait for the server to be running
+	server, err := a.waitForServer(ctx, serverName)
+	if err != nil {
 		return fmt.Errorf("wait for server %q: %w", serverName, err)
 	}
 
-	return nil
-}
-
-func (a *MachineCreateAction) createServer(
-	ctx context.Context, sshKeyName, serverName, serverSize, location string,
-) error {
-	// Find the SSH key ID from Hetzner
-	sshKeys, err := a.hetzner.SSHKey.All(ctx)
-	if err != nil {
-		return fmt.Errorf("list ssh keys: %w", err)
-	}
-	var sshKeyID int64 = -1
-	for _, k := range sshKeys {
-		if k.Name == sshKeyName {
-			sshKeyID = k.ID
-			break
-		}
-	}
-	if sshKeyID == -1 {
-		return fmt.Errorf("ssh key %q not found on hetzner", sshKeyName)
+	// Wait for the user data to finish, as the server cannot be reconciled before the `deploy` user can log in
+	if err := waitForMachine(ctx, cmd, server); err != nil {
+		return err
 	}
 
-	// Create the server on Hetzner
-	fmt.Printf("Creating server %q on Hetzner...\n", serverName)
-	server, _, err := a.hetzner.Server.Create(ctx, hcloud.ServerCreateOpts{
-		Image:      &hcloud.Image{Name: "ubuntu-24.04"},
-		Location:   &hcloud.Location{Name: location},
-		Name:       serverName,
-		SSHKeys:    []*hcloud.SSHKey{{ID: sshKeyID}},
-		ServerType: &hcloud.ServerType{Name: serverSize},
-		UserData:   strings.ReplaceAll(userData, "{{PORT}}", strconv.Itoa(constant.SSH.Port)),
-	})
-	if err != nil {
-		return fmt.Errorf("create server %q on hetzner: %w", serverName, err)
-	}
-	if server.Server == nil {
-		return fmt.Errorf("failed to create server %q on hetzner", serverName)
+	// Reconcile the server right away if asked to, under the name it was created with
+	if cmd.Bool("up") {
+		if err := cmd.Set("server-name", serverName); err != nil {
+			return err
+		}
+		return NewMachineUpAction(a.version).Action(ctx, cmd)
 	}
 
 	return nil
@@ -108,27 +86,24 @@ func (a *MachineCreateAction) createServer(
 
 func (a *MachineCreateAction) waitForServer(
 	ctx context.Context, serverName string,
-) error {
+) (*provider.Server, error) {
 	var (
-		server          *hcloud.Server
+		server          *provider.Server
 		maxWaitDuration = 5 * time.Minute
 		waitStartTime   = time.Now()
 	)
 	for {
 		time.Sleep(5 * time.Second)
 		if time.Since(waitStartTime) > maxWaitDuration {
-			return fmt.Errorf("timed out waiting for server %q to be running", serverName)
+			return nil, fmt.Errorf("timed out waiting for server %q to be running", serverName)
 		}
 
-		s, _, err := a.hetzner.Server.GetByName(ctx, serverName)
-		server = s
+		s, err := a.provider.Resolve(ctx, serverName)
 		if err != nil {
-			return fmt.Errorf("fetch server %q: %w", serverName, err)
+			return nil, err
 		}
-		if server == nil {
-			return fmt.Errorf("server %q not found", serverName)
-		}
-		if s.Status == hcloud.ServerStatusRunning {
+		server = s
+		if s.Status == provider.StatusRunning {
 			break
 		}
 
@@ -136,12 +111,12 @@ func (a *MachineCreateAction) waitForServer(
 	}
 
 	fmt.Printf("Server %q created successfully\n", serverName)
-	fmt.Printf("  ID:   %d\n", server.ID)
+	fmt.Printf("  ID:   %s\n", server.ID)
 	fmt.Printf("  Name: %s\n", server.Name)
-	fmt.Printf("  IPv4: %s\n", server.PublicNet.IPv4.IP.String())
-	fmt.Printf("  IPv6: %s\n", server.PublicNet.IPv6.IP.String())
+	fmt.Printf("  IPv4: %s\n", server.Host)
+	fmt.Printf("  IPv6: %s\n", server.IPv6)
 
-	return nil
+	return server, nil
 }
 
 var userData = `#!/bin/bash
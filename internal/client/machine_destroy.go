@@ -0,0 +1,85 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/markusylisiurunen/ship/internal/provider"
+	"github.com/urfave/cli/v3"
+	"golang.org/x/term"
+)
+
+type MachineDestroyAction struct {
+	version  string
+	provider provider.Provider
+}
+
+func NewMachineDestroyAction(version string) *MachineDestroyAction {
+	return &MachineDestroyAction{version: version}
+}
+
+func (a *MachineDestroyAction) Action(ctx context.Context, cmd *cli.Command) error {
+	// Initialize the provider
+	p, err := newProvider(cmd)
+	if err != nil {
+		return err
+	}
+	a.provider = p
+
+	// Resolve the server and make sure the user means it
+	server, err := resolveServer(ctx, cmd)
+	if err != nil {
+		return err
+	}
+	if err := requireManaged(cmd, server, "destroy"); err != nil {
+		return err
+	}
+	fmt.Printf("Destroying server %q (%s) deletes it and everything on it for good\n", server.Name, server.Host)
+	if err := confirmServerName(server.Name, cmd.String("confirm")); err != nil {
+		return err
+	}
+
+	// Delete the server and forget its host key, as the address may be handed to another server
+	fmt.Printf("Destroying server %q...\n", server.Name)
+	if err := a.provider.Delete(ctx, server.Name); err != nil {
+		return err
+	}
+	hostKeys, err := openKnownHosts()
+	if err != nil {
+		return err
+	}
+	if err := hostKeys.remove(server.Address()); err != nil {
+		return fmt.Errorf("forget host key of server %q: %w", server.Name, err)
+	}
+	fmt.Printf("Server %q destroyed\n", server.Name)
+
+	return nil
+}
+
+// requireManaged refuses an operation on a server ship did not create, unless --force-unmanaged is passed.
+func requireManaged(cmd *cli.Command, server *provider.Server, operation string) error {
+	if !server.Managed && !cmd.Bool("force-unmanaged") {
+		return fmt.Errorf("server %q was not created by ship, pass --force-unmanaged to %s it anyway", server.Name, operation)
+	}
+	return nil
+}
+
+// confirmServerName makes the user type the name of the server before a destructive operation. Without a terminal,
+// the name has to be given with --confirm instead.
+func confirmServerName(serverName, confirmed string) error {
+	if confirmed == "" {
+		if !term.IsTerminal(int(os.Stdin.Fd())) {
+			return fmt.Errorf("stdin is not a terminal, pass --confirm %s to confirm", serverName)
+		}
+		fmt.Printf("Type the server name to confirm: ")
+		answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		confirmed = strings.TrimSpace(answer)
+	}
+	if confirmed != serverName {
+		return fmt.Errorf("confirmation %q does not match server name %q", confirmed, serverName)
+	}
+	return nil
+}
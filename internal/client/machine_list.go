@@ -0,0 +1,56 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/markusylisiurunen/ship/internal/provider"
+	"github.com/urfave/cli/v3"
+)
+
+type MachineListAction struct {
+	version  string
+	provider provider.Provider
+}
+
+func NewMachineListAction(version string) *MachineListAction {
+	return &MachineListAction{version: version}
+}
+
+func (a *MachineListAction) Action(ctx context.Context, cmd *cli.Command) error {
+	// Initialize the provider
+	p, err := newProvider(cmd)
+	if err != nil {
+		return err
+	}
+	a.provider = p
+
+	// List the servers created by ship
+	servers, err := a.provider.List(ctx)
+	if err != nil {
+		return err
+	}
+	if len(servers) == 0 {
+		fmt.Printf("No machines found\n")
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tSTATUS\tSIZE\tLOCATION\tIPV4\tCREATED")
+	for _, s := range servers {
+		created := "-"
+		if !s.Created.IsZero() {
+			created = s.Created.Local().Format("2006-01-02 15:04:05")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", s.Name, s.Status, orDash(s.Size), orDash(s.Location), s.Host, created)
+	}
+	return w.Flush()
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
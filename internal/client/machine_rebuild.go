@@ -0,0 +1,63 @@
+package client
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/markusylisiurunen/ship/internal/provider"
+	"github.com/urfave/cli/v3"
+)
+
+type MachineRebuildAction struct {
+	version  string
+	provider provider.Provider
+}
+
+func NewMachineRebuildAction(version string) *MachineRebuildAction {
+	return &MachineRebuildAction{version: version}
+}
+
+func (a *MachineRebuildAction) Action(ctx context.Context, cmd *cli.Command) error {
+	// Initialize the provider
+	p, err := newProvider(cmd)
+	if err != nil {
+		return err
+	}
+	a.provider = p
+
+	// Validate the machine spec before wiping the server
+	if _, err := loadMachineSpec(cmd); err != nil {
+		return err
+	}
+
+	// Resolve the server and make sure the user means it
+	server, err := resolveServer(ctx, cmd)
+	if err != nil {
+		return err
+	}
+	if err := requireManaged(cmd, server, "rebuild"); err != nil {
+		return err
+	}
+	fmt.Printf("Rebuilding server %q (%s) reinstalls it and wipes everything on it, including apps and secrets\n",
+		server.Name, server.Host)
+	if err := confirmServerName(server.Name, cmd.String("confirm")); err != nil {
+		return err
+	}
+
+	// Reinstall the server
+	fmt.Printf("Rebuilding server %q...\n", server.Name)
+	if err := a.provider.Rebuild(ctx, server.Name); err != nil {
+		return err
+	}
+
+	// Wait for the user data to set the server up again and reconcile it
+	if err := waitForMachine(ctx, cmd, server); err != nil {
+		return err
+	}
+	if err := NewMachineUpAction(a.version).Action(ctx, cmd); err != nil {
+		return err
+	}
+	fmt.Printf("Server %q rebuilt\n", server.Name)
+
+	return nil
+}
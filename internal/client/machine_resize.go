@@ -0,0 +1,57 @@
+package client
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/markusylisiurunen/ship/internal/provider"
+	"github.com/urfave/cli/v3"
+)
+
+type MachineResizeAction struct {
+	version  string
+	provider provider.Provider
+}
+
+func NewMachineResizeAction(version string) *MachineResizeAction {
+	return &MachineResizeAction{version: version}
+}
+
+func (a *MachineResizeAction) Action(ctx context.Context, cmd *cli.Command) error {
+	// Initialize the provider
+	p, err := newProvider(cmd)
+	if err != nil {
+		return err
+	}
+	a.provider = p
+
+	// Resolve the server and check whether it needs to be resized at all
+	serverSize := cmd.String("size")
+	if serverSize == "" {
+		return fmt.Errorf("server size is required")
+	}
+	server, err := resolveServer(ctx, cmd)
+	if err != nil {
+		return err
+	}
+	if err := requireManaged(cmd, server, "resize"); err != nil {
+		return err
+	}
+	if server.Size == serverSize {
+		fmt.Printf("Server %q is already of size %s\n", server.Name, serverSize)
+		return nil
+	}
+
+	// Resize the server, which shuts it down for the duration of the change
+	fmt.Printf("Resizing server %q from %s to %s, which shuts it down for a few minutes...\n",
+		server.Name, orDash(server.Size), serverSize)
+	if err := a.provider.Resize(ctx, server.Name, provider.ResizeOpts{
+		Size:        serverSize,
+		UpgradeDisk: cmd.Bool("upgrade-disk"),
+	}); err != nil {
+		return err
+	}
+	fmt.Printf("Server %q resized to %s and running again\n", server.Name, serverSize)
+
+	return nil
+}
@@ -0,0 +1,64 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/urfave/cli/v3"
+	"golang.org/x/crypto/ssh"
+)
+
+type MachineTrustAction struct {
+	version string
+}
+
+func NewMachineTrustAction(version string) *MachineTrustAction {
+	return &MachineTrustAction{version: version}
+}
+
+func (a *MachineTrustAction) Action(ctx context.Context, cmd *cli.Command) error {
+	// Resolve the server and the SSH key to authenticate with
+	server, err := resolveServer(ctx, cmd)
+	if err != nil {
+		return err
+	}
+	signer, err := loadSigner(cmd)
+	if err != nil {
+		return err
+	}
+	hostKeys, err := openKnownHosts()
+	if err != nil {
+		return err
+	}
+
+	// Connect to the server and capture whatever host key it presents
+	var hostKey ssh.PublicKey
+	address := server.Address()
+	client, err := dialSSH(ctx, address, &ssh.ClientConfig{
+		Auth: []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: func(_ string, _ net.Addr, key ssh.PublicKey) error {
+			hostKey = key
+			return nil
+		},
+		Timeout: targetDialTimeout,
+		User:    "deploy",
+	})
+	if err != nil {
+		return fmt.Errorf("connect to server %q over ssh: %w", server.Name, err)
+	}
+	client.Close()
+
+	// Refuse to pin a key that does not match the expected fingerprint
+	fingerprint := ssh.FingerprintSHA256(hostKey)
+	if expected := cmd.String("fingerprint"); expected != "" && expected != fingerprint {
+		return fmt.Errorf("server %q presented host key %s, expected %s", server.Name, fingerprint, expected)
+	}
+
+	if err := hostKeys.replace(address, hostKey); err != nil {
+		return fmt.Errorf("pin host key for server %q: %w", server.Name, err)
+	}
+	fmt.Printf("Pinned host key for server %q (%s): %s\n", server.Name, address, fingerprint)
+
+	return nil
+}
@@ -1,121 +1,92 @@
 package client
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"os"
-	"time"
+	"strings"
 
-	"github.com/hetznercloud/hcloud-go/v2/hcloud"
 	"github.com/markusylisiurunen/ship/internal/constant"
+	"github.com/markusylisiurunen/ship/internal/spec"
 	"github.com/urfave/cli/v3"
 	"golang.org/x/crypto/ssh"
 )
 
 type MachineUpAction struct {
 	version string
-	hetzner *hcloud.Client
-	ssh     *ssh.Client
+	target  *target
 }
 
 func NewMachineUpAction(version string) *MachineUpAction {
 	return &MachineUpAction{version: version}
 }
 
-func (a *MachineUpAction) init(ctx context.Context, cmd *cli.Command) (cleanup func(), initErr error) {
-	cleanup = func() {
-		if a.ssh != nil {
-			a.ssh.Close()
-		}
+func (a *MachineUpAction) Action(ctx context.Context, cmd *cli.Command) error {
+	// Validate the machine spec before touching the server
+	machineSpec, err := loadMachineSpec(cmd)
+	if err != nil {
+		return err
 	}
 
-	token := cmd.String("token")
-	if token == "" {
-		initErr = fmt.Errorf("hetzner API token is required")
-		return
+	// Connect to the server and ensure the `agent` binary is on it
+	t, err := connectAndEnsureAgent(ctx, cmd, true, a.version)
+	if err != nil {
+		return err
 	}
-	a.hetzner = hcloud.NewClient(hcloud.WithToken(token))
+	defer t.Close()
+	a.target = t
 
-	serverName := cmd.String("name")
-	if serverName == "" {
-		initErr = fmt.Errorf("server name is required")
-		return
+	// Execute the appropriate `agent` command on the machine, handing it the spec over stdin
+	upCmd := fmt.Sprintf("sudo /root/.ship/%s/agent up", a.version)
+	if cmd.Bool("plan") {
+		upCmd += " --plan"
 	}
-	server, _, err := a.hetzner.Server.GetByName(ctx, serverName)
-	if err != nil {
-		initErr = fmt.Errorf("fetch server %q: %w", serverName, err)
-		return
+	if cmd.Bool("check") {
+		upCmd += " --check"
 	}
-	if server == nil {
-		initErr = fmt.Errorf("server %q not found", serverName)
-		return
+	if only := cmd.StringSlice("only"); len(only) > 0 {
+		upCmd += " --only " + shellQuote(strings.Join(only, ","))
 	}
-
-	sshPrivateKey := cmd.String("ssh-private-key")
-	if sshPrivateKey == "" {
-		initErr = fmt.Errorf("ssh private key is required")
-		return
+	if skip := cmd.StringSlice("skip"); len(skip) > 0 {
+		upCmd += " --skip " + shellQuote(strings.Join(skip, ","))
 	}
-	privateKey, err := os.ReadFile(sshPrivateKey)
-	if err != nil {
-		initErr = fmt.Errorf("read ssh private key %q: %w", sshPrivateKey, err)
-		return
+	if machineSpec == nil {
+		err = a.target.run(ctx, upCmd)
+	} else {
+		err = a.target.runWithStdin(ctx, upCmd+" --spec -", bytes.NewReader(machineSpec))
 	}
-	signer, err := ssh.ParsePrivateKey(privateKey)
-	if err != nil {
-		initErr = fmt.Errorf("parse ssh private key: %w", err)
-		return
+	// Pass drift on with the same exit code, so that scheduled checks can tell it apart from failures
+	var exitErr *ssh.ExitError
+	if errors.As(err, &exitErr) && cmd.Bool("check") && exitErr.ExitStatus() == constant.ExitDrift {
+		return cli.Exit("", constant.ExitDrift)
 	}
-	if client, err := ssh.Dial(
-		"tcp",
-		fmt.Sprintf("%s:%d", server.PublicNet.IPv4.IP.String(), constant.SSH.Port),
-		&ssh.ClientConfig{
-			Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
-			HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-			Timeout:         10 * time.Second,
-			User:            "deploy",
-		},
-	); err != nil {
-		initErr = fmt.Errorf("connect to server %q over ssh: %w", serverName, err)
-		return
-	} else {
-		a.ssh = client
+	if err != nil {
+		return fmt.Errorf("run agent up command: %w", err)
 	}
 
-	return
+	return nil
 }
 
-func (a *MachineUpAction) Action(ctx context.Context, cmd *cli.Command) error {
-	// Initialize the Hetzner client and SSH connection
-	cleanup, err := a.init(ctx, cmd)
-	if err != nil {
-		return err
-	}
-	defer cleanup()
-
-	// Ensure the `agent` binary is on the machine
-	var copyErr error
-	if a.version == "dev" {
-		copyErr = copyDevAgentBinaryToServer(ctx, a.ssh, true)
-	} else {
-		copyErr = copyVersionedAgentBinaryToServer(ctx, a.ssh, true, a.version)
-	}
-	if copyErr != nil {
-		return fmt.Errorf("ensure agent binary on server: %w", copyErr)
+// loadMachineSpec reads and validates the machine spec given with --spec, or the one at spec.DefaultPath if there is
+// one. It returns nil without a spec, in which case the agent uses the default profile.
+func loadMachineSpec(cmd *cli.Command) ([]byte, error) {
+	path := cmd.String("spec")
+	if path == "" {
+		if _, err := os.Stat(spec.DefaultPath); errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("error checking machine spec %s: %w", spec.DefaultPath, err)
+		}
+		path = spec.DefaultPath
 	}
-
-	// Execute the appropriate `agent` command on the machine
-	sess, err := a.ssh.NewSession()
+	data, err := os.ReadFile(path)
 	if err != nil {
-		return fmt.Errorf("create SSH session for up: %w", err)
+		return nil, fmt.Errorf("read machine spec %s: %w", path, err)
 	}
-	defer sess.Close()
-	sess.Stdout = os.Stdout
-	sess.Stderr = os.Stderr
-	upCmd := fmt.Sprintf("sudo /root/.ship/%s/agent up", a.version)
-	if err := sess.Run(upCmd); err != nil {
-		return fmt.Errorf("run agent up command %q: %w", upCmd, err)
+	if _, err := spec.Parse(data); err != nil {
+		return nil, fmt.Errorf("machine spec %s: %w", path, err)
 	}
-
-	return nil
+	return data, nil
 }
@@ -0,0 +1,95 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/markusylisiurunen/ship/internal/provider"
+	"github.com/urfave/cli/v3"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	machineReadyTimeout  = 10 * time.Minute
+	machineReadyInterval = 5 * time.Second
+)
+
+// waitForMachine waits until a freshly installed server accepts SSH connections as the `deploy` user and cloud-init
+// has finished setting it up. Any host key pinned for the server's address belongs to a previous installation, so it
+// is forgotten and the key the server presents is pinned instead.
+func waitForMachine(ctx context.Context, cmd *cli.Command, server *provider.Server) error {
+	signer, err := loadSigner(cmd)
+	if err != nil {
+		return err
+	}
+	hostKeys, err := openKnownHosts()
+	if err != nil {
+		return err
+	}
+	if err := hostKeys.remove(server.Address()); err != nil {
+		return fmt.Errorf("forget host key of server %q: %w", server.Name, err)
+	}
+
+	// The user data moves the SSH daemon to its port and creates the `deploy` user, so the connection fails until then
+	var (
+		address  = server.Address()
+		keyErr   error
+		pin      = hostKeys.callback(server.Name)
+		deadline = time.Now().Add(machineReadyTimeout)
+		client   *ssh.Client
+	)
+	config := &ssh.ClientConfig{
+		Auth: []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			keyErr = pin(hostname, remote, key)
+			return keyErr
+		},
+		Timeout: targetDialTimeout,
+		User:    "deploy",
+	}
+	fmt.Printf("Waiting for server %q to accept SSH connections...\n", server.Name)
+	for {
+		dialer := net.Dialer{Timeout: config.Timeout}
+		conn, err := dialer.DialContext(ctx, "tcp", address)
+		if err == nil {
+			c, chans, reqs, handshakeErr := ssh.NewClientConn(conn, address, config)
+			if handshakeErr == nil {
+				client = ssh.NewClient(c, chans, reqs)
+				break
+			}
+			conn.Close()
+			err = handshakeErr
+		}
+		if keyErr != nil {
+			return keyErr
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for server %q to accept SSH connections: %w", server.Name, err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(machineReadyInterval):
+		}
+	}
+
+	t := &target{name: server.Name, address: address, ssh: client, done: make(chan struct{})}
+	go t.keepalive()
+	defer t.Close()
+
+	// Exit status 2 means cloud-init finished with recoverable errors, which should not stop the setup
+	fmt.Printf("Waiting for cloud-init to finish on server %q...\n", server.Name)
+	if err := t.run(ctx, "sudo cloud-init status --wait"); err != nil {
+		var exitErr *ssh.ExitError
+		if !errors.As(err, &exitErr) || exitErr.ExitStatus() != 2 {
+			return fmt.Errorf("wait for cloud-init on server %q: %w", server.Name, err)
+		}
+		fmt.Printf("cloud-init finished with recoverable errors on server %q\n", server.Name)
+	}
+	fmt.Printf("Server %q is ready\n", server.Name)
+
+	return nil
+}
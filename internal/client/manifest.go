@@ -0,0 +1,96 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+
+	"github.com/markusylisiurunen/ship/internal/manifest"
+	"github.com/urfave/cli/v3"
+)
+
+// applyManifest fills in the flags of a command that were not set explicitly from the project manifest.
+func applyManifest(ctx context.Context, cmd *cli.Command) (context.Context, error) {
+	return applyManifestDefaults(ctx, cmd, nil)
+}
+
+// applyMachineCreateManifest is applyManifest for `machine create`, which also takes the size of the server to create
+// from the manifest. Other commands with a size flag, such as `machine resize`, must be given it explicitly.
+func applyMachineCreateManifest(ctx context.Context, cmd *cli.Command) (context.Context, error) {
+	return applyManifestDefaults(ctx, cmd, func(m *manifest.Manifest) map[string][]string {
+		return map[string][]string{"size": {m.Hetzner.Size}}
+	})
+}
+
+// applyManifestDefaults fills in the flags shared by all commands, and the ones returned by extra if given, from the
+// project manifest.
+func applyManifestDefaults(
+	ctx context.Context,
+	cmd *cli.Command,
+	extra func(m *manifest.Manifest) map[string][]string,
+) (context.Context, error) {
+	path := cmd.String("manifest")
+	var (
+		m   *manifest.Manifest
+		err error
+	)
+	if cmd.IsSet("manifest") {
+		m, err = manifest.Load(path)
+	} else {
+		m, err = manifest.LoadIfExists(path)
+	}
+	if err != nil {
+		return ctx, err
+	}
+	if m == nil {
+		return ctx, nil
+	}
+
+	sshPrivateKey, err := m.SSHPrivateKeyPath()
+	if err != nil {
+		return ctx, err
+	}
+	var port, keepReleases, gitignore string
+	if m.Server.Port != 0 {
+		port = strconv.Itoa(m.Server.Port)
+	}
+	if m.App.KeepReleases != 0 {
+		keepReleases = strconv.Itoa(m.App.KeepReleases)
+	}
+	if m.Archive.Gitignore {
+		gitignore = "true"
+	}
+	defaults := map[string][]string{
+		"token":           {m.Token()},
+		"ssh-private-key": {sshPrivateKey},
+		"ssh-key-name":    {m.Hetzner.SSHKeyName},
+		"location":        {m.Hetzner.Location},
+		"server-name":     {m.Server.Name},
+		"host":            {m.Server.Host},
+		"port":            {port},
+		"app-name":        {m.App.Name},
+		"volume-name":     m.App.Volumes,
+		"keep-releases":   {keepReleases},
+		"gitignore":       {gitignore},
+		"allow-sensitive": m.Archive.AllowSensitive,
+	}
+	if extra != nil {
+		maps.Copy(defaults, extra(m))
+	}
+	for _, flag := range cmd.Flags {
+		name := flag.Names()[0]
+		values, ok := defaults[name]
+		if !ok || cmd.IsSet(name) {
+			continue
+		}
+		for _, v := range slices.DeleteFunc(slices.Clone(values), func(v string) bool { return v == "" }) {
+			if err := cmd.Set(name, v); err != nil {
+				return ctx, fmt.Errorf("set flag %q from manifest %s: %w", name, path, err)
+			}
+		}
+	}
+
+	return ctx, nil
+}
@@ -0,0 +1,57 @@
+package client
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/urfave/cli/v3"
+)
+
+func TestApplyManifestSize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ship.toml")
+	if err := os.WriteFile(path, []byte("[hetzner]\nsize = \"cx32\"\nlocation = \"fsn1\"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name         string
+		args         []string
+		wantSize     string
+		wantLocation string
+	}{
+		{name: "create", args: []string{"create"}, wantSize: "cx32", wantLocation: "fsn1"},
+		{name: "create with size", args: []string{"create", "--size", "cx42"}, wantSize: "cx42", wantLocation: "fsn1"},
+		{name: "resize", args: []string{"resize"}, wantSize: "", wantLocation: "fsn1"},
+		{name: "resize with size", args: []string{"resize", "--size", "cx42"}, wantSize: "cx42", wantLocation: "fsn1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var size, location string
+			action := func(_ context.Context, cmd *cli.Command) error {
+				size, location = cmd.String("size"), cmd.String("location")
+				return nil
+			}
+			flags := func() []cli.Flag {
+				return []cli.Flag{&cli.StringFlag{Name: "size"}, &cli.StringFlag{Name: "location"}}
+			}
+			root := &cli.Command{
+				Name:  "ship",
+				Flags: []cli.Flag{&cli.StringFlag{Name: "manifest", Value: path}},
+				Commands: []*cli.Command{{
+					Name: "machine",
+					Commands: []*cli.Command{
+						{Name: "create", Flags: flags(), Before: applyMachineCreateManifest, Action: action},
+						{Name: "resize", Flags: flags(), Before: applyManifest, Action: action},
+					},
+				}},
+			}
+			if err := root.Run(context.Background(), append([]string{"ship", "machine"}, tt.args...)); err != nil {
+				t.Fatalf("Run() error = %v", err)
+			}
+			if size != tt.wantSize || location != tt.wantLocation {
+				t.Errorf("size, location = %q, %q, want %q, %q", size, location, tt.wantSize, tt.wantLocation)
+			}
+		})
+	}
+}
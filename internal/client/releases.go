@@ -0,0 +1,80 @@
+package client
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/markusylisiurunen/ship/internal/applock"
+	"github.com/urfave/cli/v3"
+)
+
+type ReleasesListAction struct {
+	version string
+	target  *target
+}
+
+func NewReleasesListAction(version string) *ReleasesListAction {
+	return &ReleasesListAction{version: version}
+}
+
+func (a *ReleasesListAction) Action(ctx context.Context, cmd *cli.Command) error {
+	appName := cmd.String("app-name")
+	if err := validateName("app name", appName); err != nil {
+		return err
+	}
+
+	// Connect to the server and ensure the `agent` binary is on it
+	t, err := connectAndEnsureAgent(ctx, cmd, false, a.version)
+	if err != nil {
+		return err
+	}
+	defer t.Close()
+	a.target = t
+
+	// Execute the appropriate `agent` command on the machine
+	listCmd := fmt.Sprintf("/home/deploy/.ship/%s/agent releases list --app-name %s", a.version, appName)
+	if err := a.target.run(ctx, listCmd); err != nil {
+		return fmt.Errorf("run releases list command: %w", err)
+	}
+
+	return nil
+}
+
+type ReleasesPruneAction struct {
+	version string
+	target  *target
+}
+
+func NewReleasesPruneAction(version string) *ReleasesPruneAction {
+	return &ReleasesPruneAction{version: version}
+}
+
+func (a *ReleasesPruneAction) Action(ctx context.Context, cmd *cli.Command) error {
+	var (
+		appName = cmd.String("app-name")
+		keep    = cmd.Int("keep")
+	)
+	if err := validateName("app name", appName); err != nil {
+		return err
+	}
+	if keep < 0 {
+		return fmt.Errorf("number of releases to keep cannot be negative")
+	}
+
+	// Connect to the server and ensure the `agent` binary is on it
+	t, err := connectAndEnsureAgent(ctx, cmd, false, a.version)
+	if err != nil {
+		return err
+	}
+	defer t.Close()
+	a.target = t
+
+	// Execute the appropriate `agent` command on the machine
+	pruneCmd := fmt.Sprintf("/home/deploy/.ship/%s/agent releases prune --app-name %s --keep %d --lock-holder %s",
+		a.version, appName, keep, shellQuote(applock.LocalUser()))
+	if err := a.target.run(ctx, pruneCmd); err != nil {
+		return fmt.Errorf("run releases prune command: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,53 @@
+package client
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/markusylisiurunen/ship/internal/applock"
+	"github.com/urfave/cli/v3"
+)
+
+type RollbackAction struct {
+	version string
+	target  *target
+}
+
+func NewRollbackAction(version string) *RollbackAction {
+	return &RollbackAction{version: version}
+}
+
+func (a *RollbackAction) Action(ctx context.Context, cmd *cli.Command) error {
+	var (
+		appName   = cmd.String("app-name")
+		toVersion = cmd.String("to")
+	)
+	if err := validateName("app name", appName); err != nil {
+		return err
+	}
+	if toVersion != "" {
+		if err := validateName("version", toVersion); err != nil {
+			return err
+		}
+	}
+
+	// Connect to the server and ensure the `agent` binary is on it
+	t, err := connectAndEnsureAgent(ctx, cmd, false, a.version)
+	if err != nil {
+		return err
+	}
+	defer t.Close()
+	a.target = t
+
+	// Execute the appropriate `agent` command on the machine
+	rollbackCmd := fmt.Sprintf("/home/deploy/.ship/%s/agent rollback --app-name %s", a.version, appName)
+	if toVersion != "" {
+		rollbackCmd += fmt.Sprintf(" --to %s", toVersion)
+	}
+	rollbackCmd += fmt.Sprintf(" --lock-holder %s", shellQuote(applock.LocalUser()))
+	if err := a.target.run(ctx, rollbackCmd); err != nil {
+		return fmt.Errorf("run rollback command: %w", err)
+	}
+
+	return nil
+}
@@ -7,9 +7,8 @@ import (
 	"os/exec"
 	"strings"
 
-	"github.com/bramvdbogaerde/go-scp"
+	"github.com/markusylisiurunen/ship/internal/manifest"
 	"github.com/urfave/cli/v3"
-	"golang.org/x/crypto/ssh"
 )
 
 func Execute(ctx context.Context, version string) {
@@ -17,6 +16,9 @@ func Execute(ctx context.Context, version string) {
 		Name:    "ship",
 		Usage:   "deploy apps to a VPS on Hetzner",
 		Version: version,
+		Flags: []cli.Flag{
+			&cli.StringFlag{Name: "manifest", Usage: "project manifest file path", Value: manifest.DefaultPath},
+		},
 		Commands: []*cli.Command{
 			{
 				Name:  "machine",
@@ -28,33 +30,89 @@ func Execute(ctx context.Context, version string) {
 						Flags: []cli.Flag{
 							&cli.StringFlag{Name: "token", Usage: "Hetzner API token", Required: true},
 							&cli.StringFlag{Name: "ssh-key-name", Usage: "Hetzner SSH key name", Required: true},
-							&cli.StringFlag{Name: "name", Usage: "Hetzner server name"},
+							&cli.StringFlag{Name: "server-name", Aliases: []string{"name"}, Usage: "server name"},
 							&cli.StringFlag{Name: "size", Usage: "Hetzner server size", Value: "cx22"},
 							&cli.StringFlag{Name: "location", Usage: "Hetzner location", Value: "hel1"},
+							&cli.StringFlag{Name: "ssh-private-key", Usage: "SSH private key file path", Required: true},
+							&cli.BoolFlag{Name: "up", Usage: "reconcile the machine right after creating it"},
+							&cli.StringFlag{Name: "spec", Usage: "machine spec file path (defaults to .ship/machine.toml if it exists)"},
 						},
+						Before: applyMachineCreateManifest,
 						Action: NewMachineCreateAction(version).Action,
 					},
 					{
 						Name:  "up",
 						Usage: "reconcile a machine on Hetzner to an up-to-date state",
-						Flags: []cli.Flag{
-							&cli.StringFlag{Name: "token", Usage: "Hetzner API token", Required: true},
-							&cli.StringFlag{Name: "ssh-private-key", Usage: "SSH private key file path", Required: true},
-							&cli.StringFlag{Name: "name", Usage: "Hetzner server name", Required: true},
-						},
+						Flags: append(targetFlags(),
+							&cli.BoolFlag{Name: "plan", Usage: "only print the changes that would be made"},
+							&cli.BoolFlag{Name: "check", Usage: "only report drift from the spec, exiting with 3 if there is any"},
+							&cli.StringSliceFlag{Name: "only", Usage: "only run the named steps, e.g. ufw,caddy"},
+							&cli.StringSliceFlag{Name: "skip", Usage: "skip the named steps, e.g. node"},
+							&cli.StringFlag{Name: "spec", Usage: "machine spec file path (defaults to .ship/machine.toml if it exists)"},
+						),
+						Before: applyManifest,
 						Action: NewMachineUpAction(version).Action,
 					},
 					{
 						Name:  "maintain",
 						Usage: "run maintenance tasks on a machine on Hetzner",
-						Flags: []cli.Flag{
-							&cli.StringFlag{Name: "token", Usage: "Hetzner API token", Required: true},
-							&cli.StringFlag{Name: "ssh-private-key", Usage: "SSH private key file path", Required: true},
-							&cli.StringFlag{Name: "name", Usage: "Hetzner server name", Required: true},
+						Flags: append(targetFlags(),
 							&cli.BoolFlag{Name: "allow-reboot", Usage: "reboot the machine if necessary", Value: false},
-						},
+						),
+						Before: applyManifest,
 						Action: NewMachineMaintainAction(version).Action,
 					},
+					{
+						Name:  "trust",
+						Usage: "pin the current host key of a machine on Hetzner, e.g. after a rebuild",
+						Flags: append(targetFlags(),
+							&cli.StringFlag{Name: "fingerprint", Usage: "expected SHA256 host key fingerprint"},
+						),
+						Before: applyManifest,
+						Action: NewMachineTrustAction(version).Action,
+					},
+					{
+						Name:   "list",
+						Usage:  "list the machines created by ship on Hetzner",
+						Flags:  providerFlags(),
+						Before: applyManifest,
+						Action: NewMachineListAction(version).Action,
+					},
+					{
+						Name:  "destroy",
+						Usage: "delete a machine on Hetzner and everything on it",
+						Flags: append(providerFlags(),
+							&cli.StringFlag{Name: "confirm", Usage: "server name, to confirm without a prompt"},
+							&cli.BoolFlag{Name: "force-unmanaged", Usage: "destroy the machine even if ship did not create it"},
+						),
+						Before: applyManifest,
+						Action: NewMachineDestroyAction(version).Action,
+					},
+					{
+						Name:  "resize",
+						Usage: "change the size of a machine on Hetzner, shutting it down for the change",
+						Flags: append(providerFlags(),
+							&cli.StringFlag{Name: "size", Usage: "new Hetzner server size"},
+							&cli.BoolFlag{
+								Name:  "upgrade-disk",
+								Usage: "grow the disk too, which prevents resizing to a smaller size later",
+							},
+							&cli.BoolFlag{Name: "force-unmanaged", Usage: "resize the machine even if ship did not create it"},
+						),
+						Before: applyManifest,
+						Action: NewMachineResizeAction(version).Action,
+					},
+					{
+						Name:  "rebuild",
+						Usage: "reinstall a machine on Hetzner from scratch and reconcile it again",
+						Flags: append(targetFlags(),
+							&cli.StringFlag{Name: "confirm", Usage: "server name, to confirm without a prompt"},
+							&cli.StringFlag{Name: "spec", Usage: "machine spec file path (defaults to .ship/machine.toml if it exists)"},
+							&cli.BoolFlag{Name: "force-unmanaged", Usage: "rebuild the machine even if ship did not create it"},
+						),
+						Before: applyManifest,
+						Action: NewMachineRebuildAction(version).Action,
+					},
 				},
 			},
 			{
@@ -63,32 +121,182 @@ func Execute(ctx context.Context, version string) {
 				Commands: []*cli.Command{
 					{
 						Name:  "set",
-						Usage: "set a secret on a machine on Hetzner",
-						Flags: []cli.Flag{
-							&cli.StringFlag{Name: "token", Usage: "Hetzner API token", Required: true},
-							&cli.StringFlag{Name: "ssh-private-key", Usage: "SSH private key file path", Required: true},
-							&cli.StringFlag{Name: "server-name", Usage: "Hetzner server name", Required: true},
+						Usage: "set a secret on a machine on Hetzner, reading the value from stdin or a prompt",
+						Flags: append(targetFlags(),
 							&cli.StringFlag{Name: "app-name", Usage: "application name", Required: true},
 							&cli.StringFlag{Name: "secret-name", Usage: "secret name", Required: true},
-							&cli.StringFlag{Name: "secret-value", Usage: "secret value", Required: true},
-						},
+							&cli.StringFlag{Name: "from-file", Usage: "read the secret value from a file"},
+							&cli.BoolFlag{Name: "restart", Usage: "recreate the app's services that use the changed secrets"},
+						),
+						Before: applyManifest,
 						Action: NewSecretSetAction(version).Action,
 					},
+					{
+						Name:  "list",
+						Usage: "list the secrets of an app with their last modification time, without values",
+						Flags: append(targetFlags(),
+							&cli.StringFlag{Name: "app-name", Usage: "application name", Required: true},
+						),
+						Before: applyManifest,
+						Action: NewSecretListAction(version).Action,
+					},
+					{
+						Name:  "get",
+						Usage: "print the value of a secret",
+						Flags: append(targetFlags(),
+							&cli.StringFlag{Name: "app-name", Usage: "application name", Required: true},
+							&cli.StringFlag{Name: "secret-name", Usage: "secret name", Required: true},
+							&cli.BoolFlag{Name: "reveal", Usage: "confirm that the value may be printed"},
+						),
+						Before: applyManifest,
+						Action: NewSecretGetAction(version).Action,
+					},
+					{
+						Name:  "unset",
+						Usage: "remove a secret",
+						Flags: append(targetFlags(),
+							&cli.StringFlag{Name: "app-name", Usage: "application name", Required: true},
+							&cli.StringFlag{Name: "secret-name", Usage: "secret name", Required: true},
+							&cli.BoolFlag{Name: "restart", Usage: "recreate the app's services that use the changed secrets"},
+						),
+						Before: applyManifest,
+						Action: NewSecretUnsetAction(version).Action,
+					},
+					{
+						Name:  "import",
+						Usage: "make the secrets of an app match a dotenv file, removing secrets not in the file",
+						Flags: append(targetFlags(),
+							&cli.StringFlag{Name: "app-name", Usage: "application name", Required: true},
+							&cli.StringFlag{Name: "from", Usage: "dotenv file to import", Value: ".env"},
+							&cli.BoolFlag{Name: "dry-run", Usage: "only print the changes"},
+							&cli.BoolFlag{Name: "yes", Usage: "import without asking for confirmation"},
+							&cli.BoolFlag{Name: "restart", Usage: "recreate the app's services that use the changed secrets"},
+						),
+						Before: applyManifest,
+						Action: NewSecretImportAction(version).Action,
+					},
 				},
 			},
 			{
 				Name:  "deploy",
 				Usage: "deploy an app to a machine on Hetzner",
-				Flags: []cli.Flag{
-					&cli.StringFlag{Name: "token", Usage: "Hetzner API token", Required: true},
-					&cli.StringFlag{Name: "ssh-private-key", Usage: "SSH private key file path", Required: true},
-					&cli.StringFlag{Name: "server-name", Usage: "Hetzner server name", Required: true},
+				Flags: append(targetFlags(),
 					&cli.StringFlag{Name: "app-name", Usage: "application name", Required: true},
 					&cli.StringFlag{Name: "app-version", Usage: "application version", Required: true},
 					&cli.StringSliceFlag{Name: "volume-name", Usage: "volume name (can be specified multiple times)"},
-				},
+					&cli.IntFlag{Name: "keep-releases", Usage: "number of releases to keep after deploying (0 keeps all)"},
+					&cli.BoolFlag{Name: "gitignore", Usage: "also leave out the files ignored by the project's .gitignore"},
+					&cli.StringSliceFlag{
+						Name:  "allow-sensitive",
+						Usage: "gitignore pattern of sensitive files, e.g. .env, to ship anyway (can be specified multiple times)",
+					},
+				),
+				Before: applyManifest,
 				Action: NewDeployAction(version).Action,
 			},
+			{
+				Name:  "rollback",
+				Usage: "roll an app back to a previous release",
+				Flags: append(targetFlags(),
+					&cli.StringFlag{Name: "app-name", Usage: "application name", Required: true},
+					&cli.StringFlag{Name: "to", Usage: "version to roll back to (defaults to the previous release)"},
+				),
+				Before: applyManifest,
+				Action: NewRollbackAction(version).Action,
+			},
+			{
+				Name:  "logs",
+				Usage: "print the container logs of an app, streaming new output with --follow until Ctrl-C",
+				Flags: append(targetFlags(),
+					&cli.StringFlag{Name: "app-name", Usage: "application name", Required: true},
+					&cli.StringSliceFlag{Name: "service", Usage: "only print the logs of this service (can be specified multiple times)"},
+					&cli.BoolFlag{Name: "follow", Aliases: []string{"f"}, Usage: "keep streaming new log output"},
+					&cli.StringFlag{Name: "since", Usage: "only print logs since a timestamp or a relative time, e.g. 10m"},
+					&cli.StringFlag{Name: "tail", Usage: "number of lines to print from the end of the logs of each container"},
+				),
+				Before: applyManifest,
+				Action: NewLogsAction(version).Action,
+			},
+			{
+				Name:      "exec",
+				Usage:     "run a command in a running container of an app, e.g. ship exec --service web -- npm run migrate",
+				ArgsUsage: "-- command [args...]",
+				Flags: append(targetFlags(),
+					&cli.StringFlag{Name: "app-name", Usage: "application name", Required: true},
+					&cli.StringFlag{Name: "service", Usage: "Docker Compose service to run the command in", Required: true},
+				),
+				Before: applyManifest,
+				Action: NewExecAction(version).Action,
+			},
+			{
+				Name:  "shell",
+				Usage: "open an interactive shell in a running container of an app",
+				Flags: append(targetFlags(),
+					&cli.StringFlag{Name: "app-name", Usage: "application name", Required: true},
+					&cli.StringFlag{Name: "service", Usage: "Docker Compose service to open the shell in", Required: true},
+					&cli.StringFlag{Name: "shell", Usage: "shell to run (defaults to bash if the container has it, and sh otherwise)"},
+				),
+				Before: applyManifest,
+				Action: NewShellAction(version).Action,
+			},
+			{
+				Name:  "status",
+				Usage: "show an overview of a machine and the apps deployed on it",
+				Flags: append(targetFlags(),
+					&cli.BoolFlag{Name: "json", Usage: "print the overview as JSON"},
+				),
+				Before: applyManifest,
+				Action: NewStatusAction(version).Action,
+			},
+			{
+				Name:  "releases",
+				Usage: "manage the releases of an app on a machine",
+				Commands: []*cli.Command{
+					{
+						Name:  "list",
+						Usage: "list the releases of an app",
+						Flags: append(targetFlags(),
+							&cli.StringFlag{Name: "app-name", Usage: "application name", Required: true},
+						),
+						Before: applyManifest,
+						Action: NewReleasesListAction(version).Action,
+					},
+					{
+						Name:  "prune",
+						Usage: "remove old releases of an app, never the current one",
+						Flags: append(targetFlags(),
+							&cli.StringFlag{Name: "app-name", Usage: "application name", Required: true},
+							&cli.IntFlag{Name: "keep", Usage: "number of most recent releases to keep", Value: 5},
+						),
+						Before: applyManifest,
+						Action: NewReleasesPruneAction(version).Action,
+					},
+				},
+			},
+			{
+				Name:  "lock",
+				Usage: "inspect and break the deploy lock of an app on a machine",
+				Commands: []*cli.Command{
+					{
+						Name:  "status",
+						Usage: "show who holds the lock of an app",
+						Flags: append(targetFlags(),
+							&cli.StringFlag{Name: "app-name", Usage: "application name", Required: true},
+						),
+						Before: applyManifest,
+						Action: NewLockStatusAction(version).Action,
+					},
+					{
+						Name:  "break",
+						Usage: "stop the operation holding the lock of an app and release the lock",
+						Flags: append(targetFlags(),
+							&cli.StringFlag{Name: "app-name", Usage: "application name", Required: true},
+						),
+						Before: applyManifest,
+						Action: NewLockBreakAction(version).Action,
+					},
+				},
+			},
 		},
 	}
 	if err := cmd.Run(ctx, os.Args); err != nil {
@@ -97,10 +305,38 @@ func Execute(ctx context.Context, version string) {
 	}
 }
 
+// connectAndEnsureAgent connects to the server named by the command's flags and makes sure the `agent` binary matching
+// the client version is installed on it, for root if asked to.
+func connectAndEnsureAgent(ctx context.Context, cmd *cli.Command, root bool, version string) (*target, error) {
+	t, err := connectTarget(ctx, cmd)
+	if err != nil {
+		return nil, err
+	}
+	if err := ensureAgentBinary(ctx, t, root, version); err != nil {
+		t.Close()
+		return nil, err
+	}
+	return t, nil
+}
+
+// ensureAgentBinary makes sure the `agent` binary matching the client version is installed on the server.
+func ensureAgentBinary(ctx context.Context, t *target, root bool, version string) error {
+	var err error
+	if version == "dev" {
+		err = copyDevAgentBinaryToServer(ctx, t, root)
+	} else {
+		err = copyVersionedAgentBinaryToServer(ctx, t, root, version)
+	}
+	if err != nil {
+		return fmt.Errorf("ensure agent binary on server: %w", err)
+	}
+	return nil
+}
+
 // copyDevAgentBinaryToServer builds and copies the `agent` binary to the server.
 func copyDevAgentBinaryToServer(
 	ctx context.Context,
-	ssh *ssh.Client,
+	t *target,
 	root bool,
 ) error {
 	const version = "dev"
@@ -132,26 +368,19 @@ func copyDevAgentBinaryToServer(
 	}
 
 	// Prepare the server
-	sess, err := ssh.NewSession()
-	if err != nil {
-		return fmt.Errorf("open SSH session: %w", err)
-	}
-	defer sess.Close()
-	sess.Stdout = os.Stdout
-	sess.Stderr = os.Stderr
 	if root {
 		cmds := []string{
 			fmt.Sprintf("mkdir -p /home/deploy/.ship/%s", version),
 			fmt.Sprintf("sudo mkdir -p /root/.ship/%s", version),
 		}
-		if err := sess.Run(strings.Join(cmds, " && ")); err != nil {
+		if err := t.run(ctx, strings.Join(cmds, " && ")); err != nil {
 			return fmt.Errorf("prepare remote directories for root install: %w", err)
 		}
 	} else {
 		cmds := []string{
 			fmt.Sprintf("mkdir -p /home/deploy/.ship/%s", version),
 		}
-		if err := sess.Run(strings.Join(cmds, " && ")); err != nil {
+		if err := t.run(ctx, strings.Join(cmds, " && ")); err != nil {
 			return fmt.Errorf("prepare remote directories for deploy install: %w", err)
 		}
 	}
@@ -163,32 +392,19 @@ func copyDevAgentBinaryToServer(
 		return fmt.Errorf("open built agent binary %q: %w", binaryPath, err)
 	}
 	defer bin.Close()
-	client, err := scp.NewClientBySSH(ssh)
-	if err != nil {
-		return fmt.Errorf("create SCP client: %w", err)
-	}
-	defer client.Close()
 	fmt.Printf("Copying agent binary to the server...\n")
-	remotePath := fmt.Sprintf("/home/deploy/.ship/%s/agent", version)
-	if err := client.CopyFromFile(ctx, *bin, remotePath, "0744"); err != nil {
-		return fmt.Errorf("copy agent binary to %q: %w", remotePath, err)
+	if err := t.upload(ctx, bin, fmt.Sprintf("/home/deploy/.ship/%s/agent", version), "0744"); err != nil {
+		return fmt.Errorf("copy agent binary: %w", err)
 	}
 
 	// Move the binary to root if needed
 	if root {
-		sess, err := ssh.NewSession()
-		if err != nil {
-			return fmt.Errorf("open SSH session for root promotion: %w", err)
-		}
-		defer sess.Close()
-		sess.Stdout = os.Stdout
-		sess.Stderr = os.Stderr
 		cmds := []string{
 			fmt.Sprintf("sudo mv /home/deploy/.ship/%s/agent /root/.ship/%s/agent", version, version),
 			fmt.Sprintf("sudo rm -rf /home/deploy/.ship/%s", version),
 			fmt.Sprintf("sudo chown root:root /root/.ship/%s/agent", version),
 		}
-		if err := sess.Run(strings.Join(cmds, " && ")); err != nil {
+		if err := t.run(ctx, strings.Join(cmds, " && ")); err != nil {
 			return fmt.Errorf("promote agent binary to root: %w", err)
 		}
 	}
@@ -199,8 +415,8 @@ func copyDevAgentBinaryToServer(
 
 // copyVersionedAgentBinaryToServer copies a pre-built `agent` binary to the server.
 func copyVersionedAgentBinaryToServer(
-	_ context.Context,
-	ssh *ssh.Client,
+	ctx context.Context,
+	t *target,
 	root bool,
 	version string,
 ) error {
@@ -209,15 +425,6 @@ func copyVersionedAgentBinaryToServer(
 		version,
 	)
 
-	// Prepare the server and download the `agent` binary with locking
-	sess, err := ssh.NewSession()
-	if err != nil {
-		return fmt.Errorf("open SSH session: %w", err)
-	}
-	defer sess.Close()
-	sess.Stdout = os.Stdout
-	sess.Stderr = os.Stderr
-
 	// Use a lock file to prevent concurrent installations
 	var lockFile string
 	if root {
@@ -226,8 +433,9 @@ func copyVersionedAgentBinaryToServer(
 		lockFile = "/tmp/ship-agent-install-deploy.lock"
 	}
 
+	// Prepare the server and download the `agent` binary with locking
 	if root {
-		shellOneLiner := "if [ ! -f /root/.ship/%s/agent ]; then"
+		shellOneLiner := fmt.Sprintf("if [ ! -f /root/.ship/%s/agent ]; then", version)
 		shellOneLiner += fmt.Sprintf(" mkdir -p /root/.ship/%s;", version)
 		shellOneLiner += fmt.Sprintf(" curl -fsSL -o /root/.ship/%s/ship.tar.gz %s;", version, agentBinaryDownloadURL)
 		shellOneLiner += fmt.Sprintf(" tar -xzf /root/.ship/%s/ship.tar.gz -C /root/.ship/%s;", version, version)
@@ -236,11 +444,11 @@ func copyVersionedAgentBinaryToServer(
 		shellOneLiner += fmt.Sprintf(" chmod +x /root/.ship/%s/agent;", version)
 		shellOneLiner += fmt.Sprintf(" chown root:root /root/.ship/%s/agent;", version)
 		shellOneLiner += " fi"
-		if err := sess.Run(fmt.Sprintf("sudo timeout 5 flock %s sh -c '%s'", lockFile, shellOneLiner)); err != nil {
+		if err := t.run(ctx, fmt.Sprintf("sudo timeout 5 flock %s sh -c '%s'", lockFile, shellOneLiner)); err != nil {
 			return fmt.Errorf("install agent binary from %s: %w", agentBinaryDownloadURL, err)
 		}
 	} else {
-		shellOneLiner := "if [ ! -f /home/deploy/.ship/%s/agent ]; then"
+		shellOneLiner := fmt.Sprintf("if [ ! -f /home/deploy/.ship/%s/agent ]; then", version)
 		shellOneLiner += fmt.Sprintf(" mkdir -p /home/deploy/.ship/%s;", version)
 		shellOneLiner += fmt.Sprintf(" curl -fsSL -o /home/deploy/.ship/%s/ship.tar.gz %s;", version, agentBinaryDownloadURL)
 		shellOneLiner += fmt.Sprintf(" tar -xzf /home/deploy/.ship/%s/ship.tar.gz -C /home/deploy/.ship/%s;", version, version)
@@ -249,7 +457,7 @@ func copyVersionedAgentBinaryToServer(
 		shellOneLiner += fmt.Sprintf(" chmod +x /home/deploy/.ship/%s/agent;", version)
 		shellOneLiner += fmt.Sprintf(" chown deploy:deploy /home/deploy/.ship/%s/agent;", version)
 		shellOneLiner += " fi"
-		if err := sess.Run(fmt.Sprintf("timeout 5 flock %s sh -c '%s'", lockFile, shellOneLiner)); err != nil {
+		if err := t.run(ctx, fmt.Sprintf("timeout 5 flock %s sh -c '%s'", lockFile, shellOneLiner)); err != nil {
 			return fmt.Errorf("install agent binary from %s: %w", agentBinaryDownloadURL, err)
 		}
 	}
@@ -0,0 +1,204 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/markusylisiurunen/ship/internal/applock"
+	"github.com/urfave/cli/v3"
+	"golang.org/x/term"
+)
+
+type SecretImportAction struct {
+	version string
+	target  *target
+}
+
+func NewSecretImportAction(version string) *SecretImportAction {
+	return &SecretImportAction{version: version}
+}
+
+func (a *SecretImportAction) Action(ctx context.Context, cmd *cli.Command) error {
+	var (
+		appName = cmd.String("app-name")
+		from    = cmd.String("from")
+	)
+	if err := validateName("app name", appName); err != nil {
+		return err
+	}
+	confirm := !cmd.Bool("dry-run") && !cmd.Bool("yes")
+	if confirm && !term.IsTerminal(int(os.Stdin.Fd())) {
+		return fmt.Errorf("stdin is not a terminal, pass --yes to import without confirmation")
+	}
+
+	// Read the secrets from the dotenv file
+	data, err := os.ReadFile(from)
+	if err != nil {
+		return fmt.Errorf("read %s: %w", from, err)
+	}
+	values, err := parseDotenv(data)
+	if err != nil {
+		return fmt.Errorf("parse %s: %w", from, err)
+	}
+	for _, key := range dropEmptyValues(values) {
+		fmt.Printf("Skipping %s, which has an empty value\n", key)
+	}
+	payload, err := json.Marshal(values)
+	if err != nil {
+		return fmt.Errorf("encode secrets: %w", err)
+	}
+
+	// Connect to the server and ensure the `agent` binary is on it
+	t, err := connectAndEnsureAgent(ctx, cmd, false, a.version)
+	if err != nil {
+		return err
+	}
+	defer t.Close()
+	a.target = t
+
+	// Preview the changes and ask for confirmation, unless told not to
+	importCmd := fmt.Sprintf("/home/deploy/.ship/%s/agent secret import --app-name %s --lock-holder %s",
+		a.version, appName, shellQuote(applock.LocalUser()))
+	if cmd.Bool("dry-run") || confirm {
+		fmt.Printf("Changes to the secrets of app %q:\n", appName)
+		if err := a.target.runWithStdin(ctx, importCmd+" --dry-run", bytes.NewReader(payload)); err != nil {
+			return fmt.Errorf("run secret import command: %w", err)
+		}
+	}
+	if cmd.Bool("dry-run") {
+		return nil
+	}
+	if confirm {
+		fmt.Printf("Apply these changes? [y/N] ")
+		answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		if answer = strings.ToLower(strings.TrimSpace(answer)); answer != "y" && answer != "yes" {
+			fmt.Printf("Import cancelled\n")
+			return nil
+		}
+	}
+
+	// Execute the appropriate `agent` command on the machine
+	if cmd.Bool("restart") {
+		importCmd += " --restart"
+	}
+	if err := a.target.runWithStdin(ctx, importCmd, bytes.NewReader(payload)); err != nil {
+		return fmt.Errorf("run secret import command: %w", err)
+	}
+
+	return nil
+}
+
+// parseDotenv parses `KEY=value` lines, skipping blank lines and comments. Values may be unquoted, single-quoted to be
+// taken literally, or double-quoted to allow escapes such as `\n` and values spanning several lines. Values may be empty.
+func parseDotenv(data []byte) (map[string]string, error) {
+	values := map[string]string{}
+	lines := strings.Split(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n")
+	for i := 0; i < len(lines); i++ {
+		lineNo := i + 1
+		line := strings.TrimSpace(lines[i])
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		key, rest, ok := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected KEY=value", lineNo)
+		}
+		// Keys double as secret names
+		if !nameRegexp.MatchString(key) {
+			return nil, fmt.Errorf("line %d: key %q can only contain letters, numbers, dashes, and underscores", lineNo, key)
+		}
+		if _, ok := values[key]; ok {
+			return nil, fmt.Errorf("line %d: key %q is defined more than once", lineNo, key)
+		}
+		rest = strings.TrimSpace(rest)
+
+		var value string
+		switch {
+		case strings.HasPrefix(rest, "'"):
+			end := strings.Index(rest[1:], "'")
+			if end < 0 {
+				return nil, fmt.Errorf("line %d: unterminated single-quoted value", lineNo)
+			}
+			value = rest[1 : end+1]
+		case strings.HasPrefix(rest, `"`):
+			// Double-quoted values continue on the following lines until the closing quote
+			raw := rest[1:]
+			for {
+				if end, ok := closingQuote(raw); ok {
+					raw = raw[:end]
+					break
+				}
+				i++
+				if i >= len(lines) {
+					return nil, fmt.Errorf("line %d: unterminated double-quoted value", lineNo)
+				}
+				raw += "\n" + lines[i]
+			}
+			value = unescapeDotenv(raw)
+		default:
+			if idx := strings.Index(rest, " #"); idx >= 0 {
+				rest = rest[:idx]
+			}
+			value = strings.TrimSpace(rest)
+		}
+		values[key] = value
+	}
+	return values, nil
+}
+
+// dropEmptyValues removes the keys without a value, as secrets cannot be empty, and returns them sorted. Like any key
+// not in the file, they are removed from the app by the import.
+func dropEmptyValues(values map[string]string) []string {
+	var dropped []string
+	for _, key := range slices.Sorted(maps.Keys(values)) {
+		if values[key] == "" {
+			dropped = append(dropped, key)
+			delete(values, key)
+		}
+	}
+	return dropped
+}
+
+// closingQuote returns the index of the first double quote not escaped with a backslash.
+func closingQuote(s string) (int, bool) {
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+func unescapeDotenv(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i == len(s)-1 {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 't':
+			b.WriteByte('\t')
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
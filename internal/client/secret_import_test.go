@@ -0,0 +1,91 @@
+package client
+
+import (
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseDotenv(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    map[string]string
+		wantErr string
+	}{
+		{
+			name: "unquoted",
+			data: "A=1\nB = two words \n",
+			want: map[string]string{"A": "1", "B": "two words"},
+		},
+		{
+			name: "blank lines, comments and export",
+			data: "# comment\n\n  export A=1\nB=2 # trailing\n",
+			want: map[string]string{"A": "1", "B": "2"},
+		},
+		{
+			name: "crlf line endings",
+			data: "A=1\r\nB=2\r\n",
+			want: map[string]string{"A": "1", "B": "2"},
+		},
+		{
+			name: "single-quoted is literal",
+			data: `A='a\nb # not a comment $X'`,
+			want: map[string]string{"A": `a\nb # not a comment $X`},
+		},
+		{
+			name: "double-quoted escapes",
+			data: `A="line\none\ttab \"quoted\" back\\slash"`,
+			want: map[string]string{"A": "line\none\ttab \"quoted\" back\\slash"},
+		},
+		{
+			name: "double-quoted spanning lines",
+			data: "KEY=\"-----BEGIN KEY-----\nabc\n-----END KEY-----\"\nB=2\n",
+			want: map[string]string{"KEY": "-----BEGIN KEY-----\nabc\n-----END KEY-----", "B": "2"},
+		},
+		{
+			name: "escaped quote does not close the value",
+			data: "A=\"x\\\"\ny\"",
+			want: map[string]string{"A": "x\"\ny"},
+		},
+		{name: "missing equals sign", data: "A\n", wantErr: "line 1: expected KEY=value"},
+		{name: "invalid key", data: "A.B=1\n", wantErr: "line 1: key \"A.B\""},
+		{name: "duplicate key", data: "A=1\nA=2\n", wantErr: "line 2: key \"A\" is defined more than once"},
+		{name: "empty value", data: "FOO=\nB=2\n", want: map[string]string{"FOO": "", "B": "2"}},
+		{name: "empty quoted values", data: "A=''\nB=\"\"\n", want: map[string]string{"A": "", "B": ""}},
+		{name: "unterminated single quote", data: "A='x\n", wantErr: "unterminated single-quoted"},
+		{name: "unterminated double quote", data: "A=1\nB=\"x\ny\n", wantErr: "line 2: unterminated double-quoted"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseDotenv([]byte(tt.data))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("parseDotenv() error = %v, want error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseDotenv() error = %v", err)
+			}
+			if !maps.Equal(got, tt.want) {
+				t.Errorf("parseDotenv() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDropEmptyValues(t *testing.T) {
+	values, err := parseDotenv([]byte("FOO=\nBAR=1\nBAZ=''\nQUX=\" \"\n"))
+	if err != nil {
+		t.Fatalf("parseDotenv() error = %v", err)
+	}
+	dropped := dropEmptyValues(values)
+	if want := []string{"BAZ", "FOO"}; !slices.Equal(dropped, want) {
+		t.Errorf("dropEmptyValues() = %q, want %q", dropped, want)
+	}
+	if want := map[string]string{"BAR": "1", "QUX": " "}; !maps.Equal(values, want) {
+		t.Errorf("values = %q, want %q", values, want)
+	}
+}
@@ -1,124 +1,111 @@
 package client
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"io"
 	"os"
-	"strings"
-	"time"
 
-	"github.com/hetznercloud/hcloud-go/v2/hcloud"
-	"github.com/markusylisiurunen/ship/internal/constant"
+	"github.com/markusylisiurunen/ship/internal/applock"
 	"github.com/urfave/cli/v3"
-	"golang.org/x/crypto/ssh"
+	"golang.org/x/term"
 )
 
+const maxSecretSize = 1 << 20
+
 type SecretSetAction struct {
 	version string
-	hetzner *hcloud.Client
-	ssh     *ssh.Client
+	target  *target
 }
 
 func NewSecretSetAction(version string) *SecretSetAction {
 	return &SecretSetAction{version: version}
 }
 
-func (a *SecretSetAction) init(ctx context.Context, cmd *cli.Command) (cleanup func(), initErr error) {
-	cleanup = func() {
-		if a.ssh != nil {
-			a.ssh.Close()
-		}
+func (a *SecretSetAction) Action(ctx context.Context, cmd *cli.Command) error {
+	var (
+		appName    = cmd.String("app-name")
+		secretName = cmd.String("secret-name")
+	)
+	if err := validateName("app name", appName); err != nil {
+		return err
 	}
-
-	token := cmd.String("token")
-	if token == "" {
-		initErr = fmt.Errorf("hetzner API token is required")
-		return
+	if err := validateName("secret name", secretName); err != nil {
+		return err
 	}
-	a.hetzner = hcloud.NewClient(hcloud.WithToken(token))
 
-	serverName := cmd.String("server-name")
-	if serverName == "" {
-		initErr = fmt.Errorf("server name is required")
-		return
-	}
-	server, _, err := a.hetzner.Server.GetByName(ctx, serverName)
+	// Read the secret value before connecting, so that a prompt is not interleaved with the connection output
+	value, err := readSecretValue(secretName, cmd.String("from-file"))
 	if err != nil {
-		initErr = fmt.Errorf("fetch server %q: %w", serverName, err)
-		return
-	}
-	if server == nil {
-		initErr = fmt.Errorf("server %q not found", serverName)
-		return
+		return err
 	}
 
-	sshPrivateKey := cmd.String("ssh-private-key")
-	if sshPrivateKey == "" {
-		initErr = fmt.Errorf("ssh private key is required")
-		return
-	}
-	privateKey, err := os.ReadFile(sshPrivateKey)
+	// Connect to the server and ensure the `agent` binary is on it
+	t, err := connectAndEnsureAgent(ctx, cmd, false, a.version)
 	if err != nil {
-		initErr = fmt.Errorf("read ssh private key %q: %w", sshPrivateKey, err)
-		return
+		return err
 	}
-	signer, err := ssh.ParsePrivateKey(privateKey)
-	if err != nil {
-		initErr = fmt.Errorf("parse ssh private key: %w", err)
-		return
+	defer t.Close()
+	a.target = t
+
+	// Execute the appropriate `agent` command on the machine, passing the value over stdin
+	setCmd := fmt.Sprintf("/home/deploy/.ship/%s/agent secret set --app-name %s --secret-name %s --lock-holder %s",
+		a.version, appName, secretName, shellQuote(applock.LocalUser()))
+	if cmd.Bool("restart") {
+		setCmd += " --restart"
 	}
-	if client, err := ssh.Dial(
-		"tcp",
-		fmt.Sprintf("%s:%d", server.PublicNet.IPv4.IP.String(), constant.SSH.Port),
-		&ssh.ClientConfig{
-			Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
-			HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-			Timeout:         10 * time.Second,
-			User:            "deploy",
-		},
-	); err != nil {
-		initErr = fmt.Errorf("connect to server %q over ssh: %w", serverName, err)
-		return
-	} else {
-		a.ssh = client
+	if err := a.target.runWithStdin(ctx, setCmd, bytes.NewReader(value)); err != nil {
+		return fmt.Errorf("run secret set command: %w", err)
 	}
 
-	return
+	return nil
 }
 
-func (a *SecretSetAction) Action(ctx context.Context, cmd *cli.Command) error {
-	// Initialize the Hetzner client and SSH connection
-	cleanup, err := a.init(ctx, cmd)
-	if err != nil {
-		return err
-	}
-	defer cleanup()
-
-	// Write the secret to the appropriate file
+// readSecretValue reads a secret value from the given file, from stdin when it is not a terminal, or from an
+// interactive prompt otherwise. A single trailing newline is dropped from a value piped through stdin.
+func readSecretValue(secretName, fromFile string) ([]byte, error) {
 	var (
-		appName     = cmd.String("app-name")
-		secretName  = cmd.String("secret-name")
-		secretValue = cmd.String("secret-value")
+		value []byte
+		err   error
 	)
-	if appName == "" || secretName == "" || secretValue == "" {
-		return fmt.Errorf("app name, secret name and secret value are required")
-	}
-	cmds := []string{
-		fmt.Sprintf(`mkdir -p /home/deploy/apps/%s/secrets`, appName),
-		fmt.Sprintf(`echo -n %q > /home/deploy/apps/%s/secrets/%s`, secretValue, appName, secretName),
-		fmt.Sprintf(`chmod 640 /home/deploy/apps/%s/secrets/%s`, appName, secretName),
+	switch {
+	case fromFile != "":
+		value, err = readAllLimited(fromFile)
+	case !term.IsTerminal(int(os.Stdin.Fd())):
+		value, err = readAllLimited("")
+		value = bytes.TrimSuffix(bytes.TrimSuffix(value, []byte("\n")), []byte("\r"))
+	default:
+		fmt.Printf("Value for secret %q: ", secretName)
+		value, err = term.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Println()
 	}
-	sess, err := a.ssh.NewSession()
 	if err != nil {
-		return fmt.Errorf("create SSH session for secret set: %w", err)
+		return nil, fmt.Errorf("read value of secret %q: %w", secretName, err)
 	}
-	defer sess.Close()
-	sess.Stdout = os.Stdout
-	sess.Stderr = os.Stderr
-	command := strings.Join(cmds, " && ")
-	if err := sess.Run(command); err != nil {
-		return fmt.Errorf("run remote command %q: %w", command, err)
+	if len(value) == 0 {
+		return nil, fmt.Errorf("value of secret %q cannot be empty", secretName)
 	}
+	return value, nil
+}
 
-	return nil
+// readAllLimited reads a file, or stdin when the path is empty, refusing anything larger than a secret may be.
+func readAllLimited(path string) ([]byte, error) {
+	r := io.Reader(os.Stdin)
+	if path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+	value, err := io.ReadAll(io.LimitReader(r, maxSecretSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(value) > maxSecretSize {
+		return nil, fmt.Errorf("value is larger than %d bytes", maxSecretSize)
+	}
+	return value, nil
 }
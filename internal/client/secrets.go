@@ -0,0 +1,134 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/markusylisiurunen/ship/internal/applock"
+	"github.com/urfave/cli/v3"
+	"golang.org/x/term"
+)
+
+type SecretListAction struct {
+	version string
+	target  *target
+}
+
+func NewSecretListAction(version string) *SecretListAction {
+	return &SecretListAction{version: version}
+}
+
+func (a *SecretListAction) Action(ctx context.Context, cmd *cli.Command) error {
+	appName := cmd.String("app-name")
+	if err := validateName("app name", appName); err != nil {
+		return err
+	}
+
+	// Connect to the server and ensure the `agent` binary is on it
+	t, err := connectAndEnsureAgent(ctx, cmd, false, a.version)
+	if err != nil {
+		return err
+	}
+	defer t.Close()
+	a.target = t
+
+	// Execute the appropriate `agent` command on the machine
+	listCmd := fmt.Sprintf("/home/deploy/.ship/%s/agent secret list --app-name %s", a.version, appName)
+	if err := a.target.run(ctx, listCmd); err != nil {
+		return fmt.Errorf("run secret list command: %w", err)
+	}
+
+	return nil
+}
+
+type SecretGetAction struct {
+	version string
+	target  *target
+}
+
+func NewSecretGetAction(version string) *SecretGetAction {
+	return &SecretGetAction{version: version}
+}
+
+func (a *SecretGetAction) Action(ctx context.Context, cmd *cli.Command) error {
+	var (
+		appName    = cmd.String("app-name")
+		secretName = cmd.String("secret-name")
+	)
+	if err := validateName("app name", appName); err != nil {
+		return err
+	}
+	if err := validateName("secret name", secretName); err != nil {
+		return err
+	}
+	if !cmd.Bool("reveal") {
+		return fmt.Errorf("printing the value of secret %q requires --reveal", secretName)
+	}
+
+	// Connect to the server and ensure the `agent` binary is on it
+	t, err := connectAndEnsureAgent(ctx, cmd, false, a.version)
+	if err != nil {
+		return err
+	}
+	defer t.Close()
+	a.target = t
+
+	// Execute the appropriate `agent` command on the machine
+	getCmd := fmt.Sprintf("/home/deploy/.ship/%s/agent secret get --app-name %s --secret-name %s",
+		a.version, appName, secretName)
+	value, err := a.target.output(ctx, getCmd)
+	if err != nil {
+		return fmt.Errorf("run secret get command: %w", err)
+	}
+	if _, err := os.Stdout.Write(value); err != nil {
+		return fmt.Errorf("write value of secret %q: %w", secretName, err)
+	}
+	if term.IsTerminal(int(os.Stdout.Fd())) {
+		fmt.Println()
+	}
+
+	return nil
+}
+
+type SecretUnsetAction struct {
+	version string
+	target  *target
+}
+
+func NewSecretUnsetAction(version string) *SecretUnsetAction {
+	return &SecretUnsetAction{version: version}
+}
+
+func (a *SecretUnsetAction) Action(ctx context.Context, cmd *cli.Command) error {
+	var (
+		appName    = cmd.String("app-name")
+		secretName = cmd.String("secret-name")
+	)
+	if err := validateName("app name", appName); err != nil {
+		return err
+	}
+	if err := validateName("secret name", secretName); err != nil {
+		return err
+	}
+
+	// Connect to the server and ensure the `agent` binary is on it
+	t, err := connectAndEnsureAgent(ctx, cmd, false, a.version)
+	if err != nil {
+		return err
+	}
+	defer t.Close()
+	a.target = t
+
+	// Execute the appropriate `agent` command on the machine
+	unsetCmd := fmt.Sprintf("/home/deploy/.ship/%s/agent secret unset --app-name %s --secret-name %s --lock-holder %s",
+		a.version, appName, secretName, shellQuote(applock.LocalUser()))
+	if cmd.Bool("restart") {
+		unsetCmd += " --restart"
+	}
+	if err := a.target.run(ctx, unsetCmd); err != nil {
+		return fmt.Errorf("run secret unset command: %w", err)
+	}
+
+	return nil
+}
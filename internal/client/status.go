@@ -0,0 +1,122 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/markusylisiurunen/ship/internal/format"
+	"github.com/markusylisiurunen/ship/internal/status"
+	"github.com/urfave/cli/v3"
+)
+
+type StatusAction struct {
+	version string
+	target  *target
+}
+
+func NewStatusAction(version string) *StatusAction {
+	return &StatusAction{version: version}
+}
+
+func (a *StatusAction) Action(ctx context.Context, cmd *cli.Command) error {
+	// Connect to the server and ensure the `agent` binary is on it
+	t, err := connectAndEnsureAgent(ctx, cmd, false, a.version)
+	if err != nil {
+		return err
+	}
+	defer t.Close()
+	a.target = t
+
+	// Execute the appropriate `agent` command on the machine, as root so that it can look into /root
+	statusCmd := fmt.Sprintf("sudo /home/deploy/.ship/%s/agent status", a.version)
+	out, err := a.target.output(ctx, statusCmd)
+	if err != nil {
+		return fmt.Errorf("run status command: %w", err)
+	}
+	if cmd.Bool("json") {
+		_, err := os.Stdout.Write(out)
+		return err
+	}
+	var s status.Machine
+	if err := json.Unmarshal(out, &s); err != nil {
+		return fmt.Errorf("parse status: %w", err)
+	}
+	return a.render(s)
+}
+
+// render prints the machine overview followed by a table of the apps and their services.
+func (a *StatusAction) render(s status.Machine) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "Server:\t%s (%s), hostname %s\n", a.target.name, a.target.address, s.Hostname)
+	fmt.Fprintf(w, "Agents:\troot %s, deploy %s\n", listOrNone(s.RootAgents), listOrNone(s.DeployAgents))
+	fmt.Fprintf(w, "Disk:\t%s\n", formatUsage(s.Disk))
+	fmt.Fprintf(w, "Memory:\t%s\n", formatUsage(s.Memory))
+	updates := fmt.Sprintf("%d pending apt upgrade(s)", s.Upgrades)
+	if s.RebootRequired {
+		updates += ", reboot required"
+	}
+	fmt.Fprintf(w, "Updates:\t%s\n", updates)
+	fmt.Fprintf(w, "Caddy:\t%s, sites %s\n", s.Caddy.State, listOrNone(s.Caddy.Sites))
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Println()
+	if len(s.Apps) == 0 {
+		fmt.Printf("No apps deployed\n")
+	} else {
+		w = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "APP\tRELEASE\tDEPLOYED\tSERVICE\tSTATE\tHEALTH\tRESTARTS")
+		for _, app := range s.Apps {
+			release, deployed := "-", "-"
+			if app.Release != "" {
+				release = app.Release
+				deployed = app.DeployedAt.Local().Format("2006-01-02 15:04:05")
+			}
+			if len(app.Services) == 0 {
+				fmt.Fprintf(w, "%s\t%s\t%s\t-\t-\t-\t-\n", app.Name, release, deployed)
+				continue
+			}
+			for i, service := range app.Services {
+				name, rel, dep := app.Name, release, deployed
+				if i > 0 {
+					name, rel, dep = "", "", ""
+				}
+				health := service.Health
+				if health == "" {
+					health = "-"
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%d\n", name, rel, dep, service.Name, service.State, health,
+					service.Restarts)
+			}
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+	}
+
+	for _, warning := range s.Warnings {
+		fmt.Printf("Warning: %s\n", warning)
+	}
+	return nil
+}
+
+// formatUsage formats a used and total size with the percentage used.
+func formatUsage(u status.Usage) string {
+	if u.Total == 0 {
+		return "unknown"
+	}
+	return fmt.Sprintf("%s of %s used (%.0f%%)", format.Bytes(int64(u.Used)), format.Bytes(int64(u.Total)),
+		100*float64(u.Used)/float64(u.Total))
+}
+
+func listOrNone(items []string) string {
+	if len(items) == 0 {
+		return "none"
+	}
+	return strings.Join(items, ", ")
+}
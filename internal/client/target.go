@@ -0,0 +1,314 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/bramvdbogaerde/go-scp"
+	"github.com/markusylisiurunen/ship/internal/provider"
+	"github.com/urfave/cli/v3"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/term"
+)
+
+const (
+	targetDialTimeout       = 10 * time.Second
+	targetDialAttempts      = 3
+	targetKeepaliveInterval = 30 * time.Second
+	targetKeepaliveMaxMiss  = 3
+)
+
+// targetFlags returns the flags shared by every command that connects to a server.
+func targetFlags() []cli.Flag {
+	return append(providerFlags(),
+		&cli.StringFlag{Name: "ssh-private-key", Usage: "SSH private key file path", Required: true},
+	)
+}
+
+// providerFlags returns the flags that select the provider and the server on it.
+func providerFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{Name: "token", Usage: "Hetzner API token"},
+		&cli.StringFlag{Name: "server-name", Aliases: []string{"name"}, Usage: "server name"},
+		&cli.StringFlag{Name: "host", Usage: "host or IP address of a server not managed on Hetzner"},
+		&cli.IntFlag{Name: "port", Usage: "SSH port of the server given with --host", Value: 22},
+	}
+}
+
+// target is a server with an open SSH connection to it as the `deploy` user.
+type target struct {
+	name    string
+	address string
+	ssh     *ssh.Client
+	done    chan struct{}
+}
+
+// connectTarget resolves the server named by the command's flags and opens an SSH connection to it.
+func connectTarget(ctx context.Context, cmd *cli.Command) (*target, error) {
+	server, err := resolveServer(ctx, cmd)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := loadSigner(cmd)
+	if err != nil {
+		return nil, err
+	}
+	hostKeys, err := openKnownHosts()
+	if err != nil {
+		return nil, err
+	}
+
+	address := server.Address()
+	client, err := dialSSH(ctx, address, &ssh.ClientConfig{
+		Auth:              []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyAlgorithms: hostKeys.algorithms(address),
+		HostKeyCallback:   hostKeys.callback(server.Name),
+		Timeout:           targetDialTimeout,
+		User:              "deploy",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("connect to server %q over ssh: %w", server.Name, err)
+	}
+
+	t := &target{name: server.Name, address: address, ssh: client, done: make(chan struct{})}
+	go t.keepalive()
+	return t, nil
+}
+
+// newProvider returns the provider selected by the command's flags: a static provider when a host is given and
+// Hetzner otherwise.
+func newProvider(cmd *cli.Command) (provider.Provider, error) {
+	if host := cmd.String("host"); host != "" {
+		return provider.NewStatic(cmd.String("server-name"), host, int(cmd.Int("port"))), nil
+	}
+	token := cmd.String("token")
+	if token == "" {
+		return nil, fmt.Errorf("hetzner API token is required")
+	}
+	return provider.NewHetzner(token), nil
+}
+
+// resolveServer looks up the server named by the command's flags on its provider.
+func resolveServer(ctx context.Context, cmd *cli.Command) (*provider.Server, error) {
+	p, err := newProvider(cmd)
+	if err != nil {
+		return nil, err
+	}
+	serverName := cmd.String("server-name")
+	if serverName == "" && cmd.String("host") == "" {
+		return nil, fmt.Errorf("server name is required")
+	}
+	return p.Resolve(ctx, serverName)
+}
+
+// loadSigner reads and parses the SSH private key named by the command's flags.
+func loadSigner(cmd *cli.Command) (ssh.Signer, error) {
+	sshPrivateKey := cmd.String("ssh-private-key")
+	if sshPrivateKey == "" {
+		return nil, fmt.Errorf("ssh private key is required")
+	}
+	privateKey, err := os.ReadFile(sshPrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("read ssh private key %q: %w", sshPrivateKey, err)
+	}
+	signer, err := ssh.ParsePrivateKey(privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("parse ssh private key: %w", err)
+	}
+	return signer, nil
+}
+
+// dialSSH opens an SSH connection, retrying when the TCP connection cannot be established. Handshake failures, such
+// as a host key mismatch or rejected authentication, are returned immediately.
+func dialSSH(ctx context.Context, address string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	dialer := net.Dialer{Timeout: config.Timeout}
+	var lastErr error
+	for attempt := 1; attempt <= targetDialAttempts; attempt++ {
+		if attempt > 1 {
+			fmt.Printf("Connecting to %s failed, retrying (%d/%d)...\n", address, attempt, targetDialAttempts)
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(time.Duration(attempt) * 2 * time.Second):
+			}
+		}
+		conn, err := dialer.DialContext(ctx, "tcp", address)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		c, chans, reqs, err := ssh.NewClientConn(conn, address, config)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return ssh.NewClient(c, chans, reqs), nil
+	}
+	return nil, lastErr
+}
+
+// keepalive periodically pings the server and closes the connection once it stops responding.
+func (t *target) keepalive() {
+	ticker := time.NewTicker(targetKeepaliveInterval)
+	defer ticker.Stop()
+	missed := 0
+	for {
+		select {
+		case <-t.done:
+			return
+		case <-ticker.C:
+			if _, _, err := t.ssh.SendRequest("keepalive@openssh.com", true, nil); err != nil {
+				missed++
+				if missed >= targetKeepaliveMaxMiss {
+					fmt.Printf("Server %q stopped responding, closing the connection\n", t.name)
+					t.ssh.Close()
+					return
+				}
+				continue
+			}
+			missed = 0
+		}
+	}
+}
+
+// Close closes the SSH connection to the server.
+func (t *target) Close() error {
+	close(t.done)
+	return t.ssh.Close()
+}
+
+// run runs a command on the server, streaming its output to stdout and stderr.
+func (t *target) run(ctx context.Context, command string) error {
+	return t.runWithStdin(ctx, command, nil)
+}
+
+// runWithStdin runs a command on the server with the given reader as its stdin, streaming its output to stdout and
+// stderr.
+func (t *target) runWithStdin(ctx context.Context, command string, stdin io.Reader) error {
+	return t.session(ctx, func(sess *ssh.Session) error {
+		sess.Stdin = stdin
+		sess.Stdout = os.Stdout
+		sess.Stderr = os.Stderr
+		if err := sess.Run(command); err != nil {
+			return fmt.Errorf("run remote command %q: %w", command, err)
+		}
+		return nil
+	})
+}
+
+// runInteractive runs a command on the server attached to the local stdin, stdout, and stderr. With a TTY, the command
+// gets a PTY that follows the size of the local terminal, which is put in raw mode so that keys such as Ctrl-C reach
+// the command instead of the client.
+func (t *target) runInteractive(ctx context.Context, command string, tty bool) error {
+	return t.session(ctx, func(sess *ssh.Session) error {
+		sess.Stdin = os.Stdin
+		sess.Stdout = os.Stdout
+		sess.Stderr = os.Stderr
+		if tty {
+			fd := int(os.Stdin.Fd())
+			width, height, err := term.GetSize(fd)
+			if err != nil {
+				return fmt.Errorf("get terminal size: %w", err)
+			}
+			termType := os.Getenv("TERM")
+			if termType == "" {
+				termType = "xterm-256color"
+			}
+			if err := sess.RequestPty(termType, height, width, ssh.TerminalModes{ssh.ECHO: 1}); err != nil {
+				return fmt.Errorf("request PTY on server %q: %w", t.name, err)
+			}
+			state, err := term.MakeRaw(fd)
+			if err != nil {
+				return fmt.Errorf("put terminal in raw mode: %w", err)
+			}
+			defer term.Restore(fd, state)
+
+			// Follow the size of the local terminal
+			resize := make(chan os.Signal, 1)
+			signal.Notify(resize, syscall.SIGWINCH)
+			defer func() {
+				signal.Stop(resize)
+				close(resize)
+			}()
+			go func() {
+				for range resize {
+					if width, height, err := term.GetSize(fd); err == nil {
+						_ = sess.WindowChange(height, width)
+					}
+				}
+			}()
+		}
+		if err := sess.Run(command); err != nil {
+			return fmt.Errorf("run remote command %q: %w", command, err)
+		}
+		return nil
+	})
+}
+
+// output runs a command on the server and returns its stdout, streaming its stderr.
+func (t *target) output(ctx context.Context, command string) ([]byte, error) {
+	return t.outputWithStdin(ctx, command, nil)
+}
+
+// outputWithStdin runs a command on the server with the given reader as its stdin and returns its stdout, streaming
+// its stderr.
+func (t *target) outputWithStdin(ctx context.Context, command string, stdin io.Reader) ([]byte, error) {
+	var out []byte
+	err := t.session(ctx, func(sess *ssh.Session) error {
+		sess.Stdin = stdin
+		sess.Stderr = os.Stderr
+		b, err := sess.Output(command)
+		if err != nil {
+			return fmt.Errorf("run remote command %q: %w", command, err)
+		}
+		out = b
+		return nil
+	})
+	return out, err
+}
+
+// upload copies a local file to the given path on the server.
+func (t *target) upload(ctx context.Context, file *os.File, remotePath, perm string) error {
+	client, err := scp.NewClientBySSH(t.ssh)
+	if err != nil {
+		return fmt.Errorf("create SCP client: %w", err)
+	}
+	defer client.Close()
+	if err := client.CopyFromFile(ctx, *file, remotePath, perm); err != nil {
+		return fmt.Errorf("copy %q to %q: %w", file.Name(), remotePath, err)
+	}
+	return nil
+}
+
+// session opens a new SSH session, closing it early if the context is cancelled.
+func (t *target) session(ctx context.Context, f func(sess *ssh.Session) error) error {
+	sess, err := t.ssh.NewSession()
+	if err != nil {
+		return fmt.Errorf("open SSH session on server %q: %w", t.name, err)
+	}
+	defer sess.Close()
+	stop := context.AfterFunc(ctx, func() {
+		_ = sess.Signal(ssh.SIGTERM)
+		sess.Close()
+	})
+	defer stop()
+	if err := f(sess); err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return fmt.Errorf("%w: %w", ctxErr, err)
+		}
+		return err
+	}
+	return nil
+}
+
+// shellQuote quotes a string for use as a single word in a remote POSIX shell command.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
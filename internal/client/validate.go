@@ -0,0 +1,22 @@
+package client
+
+import (
+	"fmt"
+	"regexp"
+)
+
+var (
+	// nameRegexp matches app names and versions, and the names of volumes and secrets, which end up on the command line
+	// of the agent.
+	nameRegexp = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+	// serviceNameRegexp matches Docker Compose service names.
+	serviceNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]*$`)
+)
+
+// validateName checks a name matched by nameRegexp. The kind, such as "app name", is used in the error.
+func validateName(kind, name string) error {
+	if !nameRegexp.MatchString(name) {
+		return fmt.Errorf("%s %q can only contain letters, numbers, dashes, and underscores", kind, name)
+	}
+	return nil
+}
@@ -5,3 +5,6 @@ var SSH = struct {
 }{
 	Port: 42817,
 }
+
+// ExitDrift is the exit code of `machine up --check` when the machine has drifted from its spec.
+const ExitDrift = 3
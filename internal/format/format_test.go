@@ -0,0 +1,23 @@
+package format
+
+import "testing"
+
+func TestBytes(t *testing.T) {
+	tests := []struct {
+		n    int64
+		want string
+	}{
+		{n: 0, want: "0 B"},
+		{n: 1023, want: "1023 B"},
+		{n: 1024, want: "1.0 KiB"},
+		{n: 1536, want: "1.5 KiB"},
+		{n: 1024 * 1024, want: "1.0 MiB"},
+		{n: 5 * 1024 * 1024 * 1024, want: "5.0 GiB"},
+		{n: 1 << 62, want: "4.0 EiB"},
+	}
+	for _, tt := range tests {
+		if got := Bytes(tt.n); got != tt.want {
+			t.Errorf("Bytes(%d) = %q, want %q", tt.n, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package manifest
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+// DefaultPath is where the manifest is looked up relative to the project root.
+const DefaultPath = ".ship/ship.toml"
+
+type Manifest struct {
+	App         App         `toml:"app"`
+	Server      Server      `toml:"server"`
+	Hetzner     Hetzner     `toml:"hetzner"`
+	Archive     Archive     `toml:"archive"`
+	Deploy      Deploy      `toml:"deploy"`
+	HealthCheck HealthCheck `toml:"health_check"`
+	Secrets     Secrets     `toml:"secrets"`
+}
+
+type App struct {
+	Name         string   `toml:"name"`
+	Volumes      []string `toml:"volumes"`
+	KeepReleases int      `toml:"keep_releases"`
+}
+
+type Server struct {
+	Name          string `toml:"name"`
+	Host          string `toml:"host"`
+	Port          int    `toml:"port"`
+	SSHPrivateKey string `toml:"ssh_private_key"`
+}
+
+type Hetzner struct {
+	TokenEnv   string `toml:"token_env"`
+	SSHKeyName string `toml:"ssh_key_name"`
+	Size       string `toml:"size"`
+	Location   string `toml:"location"`
+}
+
+// Archive configures which files of the project go into the app archive. The .shipignore file is always honoured.
+type Archive struct {
+	Gitignore      bool     `toml:"gitignore"`
+	AllowSensitive []string `toml:"allow_sensitive"`
+}
+
+const (
+	DeployStrategyInPlace   = "in-place"
+	DeployStrategyBlueGreen = "blue-green"
+)
+
+// Deploy configures how the agent brings up a release. The "in-place" strategy replaces the running containers of
+// the app, while "blue-green" starts the release under its own Docker Compose project and switches Caddy over to it
+// once it is healthy. With "blue-green", `{{PROJECT}}` in the Caddyfile is replaced with the Compose project name so
+// that upstreams can address the release's containers, e.g. `reverse_proxy {{PROJECT}}-web-1:3000`.
+type Deploy struct {
+	Strategy string `toml:"strategy"`
+}
+
+// BlueGreen reports whether releases are deployed side by side.
+func (d Deploy) BlueGreen() bool {
+	return d.Strategy == DeployStrategyBlueGreen
+}
+
+func (d Deploy) Validate() error {
+	switch d.Strategy {
+	case "", DeployStrategyInPlace, DeployStrategyBlueGreen:
+		return nil
+	default:
+		return fmt.Errorf("deploy strategy %q must be %q or %q", d.Strategy, DeployStrategyInPlace, DeployStrategyBlueGreen)
+	}
+}
+
+// Secrets configures how the agent hands the app's secrets to a release. They are always available as files under
+// .ship/secrets. With EnvFile, they are also rendered into .ship/secrets.env, which compose.yml can load with
+// `env_file: secrets.env` since Compose resolves paths relative to the .ship directory.
+type Secrets struct {
+	EnvFile bool `toml:"env_file"`
+}
+
+const (
+	HealthCheckHTTP    = "http"
+	HealthCheckCompose = "compose"
+)
+
+// HealthCheck is evaluated by the agent after a release is brought up. An "http" check polls the URL through Caddy
+// on the machine, and a "compose" check waits for every Docker Compose container to be running and healthy.
+type HealthCheck struct {
+	Type     string        `toml:"type"`
+	URL      string        `toml:"url"`
+	Timeout  time.Duration `toml:"timeout"`
+	Interval time.Duration `toml:"interval"`
+}
+
+// Enabled reports whether a health check is configured.
+func (h HealthCheck) Enabled() bool {
+	return h.Type != ""
+}
+
+func (h HealthCheck) Validate() error {
+	switch h.Type {
+	case "":
+		return nil
+	case HealthCheckHTTP:
+		u, err := url.Parse(h.URL)
+		if err != nil {
+			return fmt.Errorf("health check url %q is invalid: %w", h.URL, err)
+		}
+		if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("health check url %q must be an absolute http or https url", h.URL)
+		}
+	case HealthCheckCompose:
+	default:
+		return fmt.Errorf("health check type %q must be %q or %q", h.Type, HealthCheckHTTP, HealthCheckCompose)
+	}
+	if h.Timeout < 0 || h.Interval < 0 {
+		return fmt.Errorf("health check timeout and interval cannot be negative")
+	}
+	return nil
+}
+
+// Load reads and decodes the manifest at the given path.
+func Load(path string) (*Manifest, error) {
+	var m Manifest
+	meta, err := toml.DecodeFile(path, &m)
+	if err != nil {
+		return nil, fmt.Errorf("decode manifest %s: %w", path, err)
+	}
+	if undecoded := meta.Undecoded(); len(undecoded) > 0 {
+		keys := make([]string, 0, len(undecoded))
+		for _, k := range undecoded {
+			keys = append(keys, k.String())
+		}
+		return nil, fmt.Errorf("manifest %s has unknown keys: %s", path, strings.Join(keys, ", "))
+	}
+	if err := m.Deploy.Validate(); err != nil {
+		return nil, fmt.Errorf("manifest %s: %w", path, err)
+	}
+	if err := m.HealthCheck.Validate(); err != nil {
+		return nil, fmt.Errorf("manifest %s: %w", path, err)
+	}
+	return &m, nil
+}
+
+// LoadIfExists reads the manifest at the given path, returning nil if the file does not exist.
+func LoadIfExists(path string) (*Manifest, error) {
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("error checking manifest %s: %w", path, err)
+	}
+	return Load(path)
+}
+
+// Token resolves the Hetzner API token from the environment variable named in the manifest.
+func (m *Manifest) Token() string {
+	if m.Hetzner.TokenEnv == "" {
+		return ""
+	}
+	return os.Getenv(m.Hetzner.TokenEnv)
+}
+
+// SSHPrivateKeyPath returns the SSH private key path with a leading `~` expanded.
+func (m *Manifest) SSHPrivateKeyPath() (string, error) {
+	path := m.Server.SSHPrivateKey
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("determine home directory: %w", err)
+		}
+		path = filepath.Join(home, strings.TrimPrefix(path, "~"))
+	}
+	return path, nil
+}
@@ -0,0 +1,221 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+	"github.com/markusylisiurunen/ship/internal/constant"
+)
+
+var _ Provider = (*Hetzner)(nil)
+
+const (
+	// hetznerImage is the operating system servers are created and rebuilt with.
+	hetznerImage = "ubuntu-24.04"
+	// managedByLabel marks the servers created by ship, so that they can be told apart from other servers in the
+	// same project.
+	managedByLabel = "managed-by"
+	managedByValue = "ship"
+	// shutdownTimeout is how long a server gets to shut down gracefully before it is powered off.
+	shutdownTimeout = 2 * time.Minute
+)
+
+// Hetzner manages servers on Hetzner Cloud.
+type Hetzner struct {
+	client *hcloud.Client
+}
+
+func NewHetzner(token string) *Hetzner {
+	return &Hetzner{client: hcloud.NewClient(hcloud.WithToken(token))}
+}
+
+func (p *Hetzner) Resolve(ctx context.Context, name string) (*Server, error) {
+	server, err := p.get(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return p.toServer(server), nil
+}
+
+func (p *Hetzner) Create(ctx context.Context, opts CreateOpts) (*Server, error) {
+	// Find the SSH key ID from Hetzner
+	sshKeys, err := p.client.SSHKey.All(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("list ssh keys: %w", err)
+	}
+	var sshKeyID int64 = -1
+	for _, k := range sshKeys {
+		if k.Name == opts.SSHKeyName {
+			sshKeyID = k.ID
+			break
+		}
+	}
+	if sshKeyID == -1 {
+		return nil, fmt.Errorf("ssh key %q not found on hetzner", opts.SSHKeyName)
+	}
+
+	// Create the server on Hetzner
+	result, _, err := p.client.Server.Create(ctx, hcloud.ServerCreateOpts{
+		Image:      &hcloud.Image{Name: hetznerImage},
+		Labels:     map[string]string{managedByLabel: managedByValue},
+		Location:   &hcloud.Location{Name: opts.Location},
+		Name:       opts.Name,
+		SSHKeys:    []*hcloud.SSHKey{{ID: sshKeyID}},
+		ServerType: &hcloud.ServerType{Name: opts.Size},
+		UserData:   opts.UserData,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("create server %q on hetzner: %w", opts.Name, err)
+	}
+	if result.Server == nil {
+		return nil, fmt.Errorf("failed to create server %q on hetzner", opts.Name)
+	}
+
+	return p.toServer(result.Server), nil
+}
+
+func (p *Hetzner) Delete(ctx context.Context, name string) error {
+	server, err := p.get(ctx, name)
+	if err != nil {
+		return err
+	}
+	result, _, err := p.client.Server.DeleteWithResult(ctx, server)
+	if err != nil {
+		return fmt.Errorf("delete server %q on hetzner: %w", name, err)
+	}
+	if err := p.client.Action.WaitFor(ctx, result.Action); err != nil {
+		return fmt.Errorf("wait for server %q to be deleted: %w", name, err)
+	}
+	return nil
+}
+
+func (p *Hetzner) List(ctx context.Context) ([]*Server, error) {
+	servers, err := p.client.Server.AllWithOpts(ctx, hcloud.ServerListOpts{
+		ListOpts: hcloud.ListOpts{LabelSelector: managedByLabel + "=" + managedByValue},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list servers on hetzner: %w", err)
+	}
+	out := make([]*Server, 0, len(servers))
+	for _, s := range servers {
+		out = append(out, p.toServer(s))
+	}
+	return out, nil
+}
+
+func (p *Hetzner) Resize(ctx context.Context, name string, opts ResizeOpts) error {
+	server, err := p.get(ctx, name)
+	if err != nil {
+		return err
+	}
+	if err := p.shutdown(ctx, server); err != nil {
+		return err
+	}
+	action, _, err := p.client.Server.ChangeType(ctx, server, hcloud.ServerChangeTypeOpts{
+		ServerType:  &hcloud.ServerType{Name: opts.Size},
+		UpgradeDisk: opts.UpgradeDisk,
+	})
+	if err != nil {
+		return fmt.Errorf("change type of server %q to %q on hetzner: %w", name, opts.Size, err)
+	}
+	if err := p.client.Action.WaitFor(ctx, action); err != nil {
+		return fmt.Errorf("wait for type of server %q to change: %w", name, err)
+	}
+	action, _, err = p.client.Server.Poweron(ctx, server)
+	if err != nil {
+		return fmt.Errorf("power on server %q on hetzner: %w", name, err)
+	}
+	if err := p.client.Action.WaitFor(ctx, action); err != nil {
+		return fmt.Errorf("wait for server %q to power on: %w", name, err)
+	}
+	return nil
+}
+
+func (p *Hetzner) Rebuild(ctx context.Context, name string) error {
+	server, err := p.get(ctx, name)
+	if err != nil {
+		return err
+	}
+	result, _, err := p.client.Server.RebuildWithResult(ctx, server, hcloud.ServerRebuildOpts{
+		Image: &hcloud.Image{Name: hetznerImage},
+	})
+	if err != nil {
+		return fmt.Errorf("rebuild server %q on hetzner: %w", name, err)
+	}
+	if err := p.client.Action.WaitFor(ctx, result.Action); err != nil {
+		return fmt.Errorf("wait for server %q to be rebuilt: %w", name, err)
+	}
+	return nil
+}
+
+// shutdown shuts a server down gracefully, powering it off if it is still running after shutdownTimeout.
+func (p *Hetzner) shutdown(ctx context.Context, server *hcloud.Server) error {
+	if server.Status == hcloud.ServerStatusOff {
+		return nil
+	}
+	action, _, err := p.client.Server.Shutdown(ctx, server)
+	if err != nil {
+		return fmt.Errorf("shut down server %q on hetzner: %w", server.Name, err)
+	}
+	if err := p.client.Action.WaitFor(ctx, action); err != nil {
+		return fmt.Errorf("wait for server %q to shut down: %w", server.Name, err)
+	}
+	// The shutdown action only sends the ACPI signal, so wait for the server to actually stop
+	deadline := time.Now().Add(shutdownTimeout)
+	for time.Now().Before(deadline) {
+		s, _, err := p.client.Server.GetByID(ctx, server.ID)
+		if err != nil {
+			return fmt.Errorf("fetch server %q: %w", server.Name, err)
+		}
+		if s != nil && s.Status == hcloud.ServerStatusOff {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(5 * time.Second):
+		}
+	}
+	action, _, err = p.client.Server.Poweroff(ctx, server)
+	if err != nil {
+		return fmt.Errorf("power off server %q on hetzner: %w", server.Name, err)
+	}
+	if err := p.client.Action.WaitFor(ctx, action); err != nil {
+		return fmt.Errorf("wait for server %q to power off: %w", server.Name, err)
+	}
+	return nil
+}
+
+func (p *Hetzner) get(ctx context.Context, name string) (*hcloud.Server, error) {
+	server, _, err := p.client.Server.GetByName(ctx, name)
+	if err != nil {
+		return nil, fmt.Errorf("fetch server %q: %w", name, err)
+	}
+	if server == nil {
+		return nil, fmt.Errorf("server %q not found", name)
+	}
+	return server, nil
+}
+
+func (p *Hetzner) toServer(s *hcloud.Server) *Server {
+	server := &Server{
+		ID:      strconv.FormatInt(s.ID, 10),
+		Name:    s.Name,
+		Status:  string(s.Status),
+		Host:    s.PublicNet.IPv4.IP.String(),
+		IPv6:    s.PublicNet.IPv6.IP.String(),
+		Port:    constant.SSH.Port,
+		Created: s.Created,
+		Managed: s.Labels[managedByLabel] == managedByValue,
+	}
+	if s.ServerType != nil {
+		server.Size = s.ServerType.Name
+	}
+	if s.Datacenter != nil && s.Datacenter.Location != nil {
+		server.Location = s.Datacenter.Location.Name
+	}
+	return server
+}
@@ -0,0 +1,63 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"time"
+)
+
+// ErrNotSupported is returned by providers for operations they cannot perform.
+var ErrNotSupported = errors.New("operation not supported by provider")
+
+const (
+	StatusRunning = "running"
+)
+
+// Server is a machine that ship connects to over SSH.
+type Server struct {
+	ID       string
+	Name     string
+	Status   string
+	Host     string
+	IPv6     string
+	Port     int
+	Size     string
+	Location string
+	Created  time.Time
+	// Managed reports whether ship created the server.
+	Managed bool
+}
+
+// Address returns the `host:port` address of the server's SSH daemon.
+func (s *Server) Address() string {
+	return net.JoinHostPort(s.Host, strconv.Itoa(s.Port))
+}
+
+type CreateOpts struct {
+	Name       string
+	SSHKeyName string
+	Size       string
+	Location   string
+	UserData   string
+}
+
+type ResizeOpts struct {
+	Size string
+	// UpgradeDisk grows the disk along with the size, which prevents resizing the server to a smaller size later.
+	UpgradeDisk bool
+}
+
+// Provider resolves and manages the servers ship deploys to.
+type Provider interface {
+	Resolve(ctx context.Context, name string) (*Server, error)
+	Create(ctx context.Context, opts CreateOpts) (*Server, error)
+	Delete(ctx context.Context, name string) error
+	// List returns the servers ship manages on the provider.
+	List(ctx context.Context) ([]*Server, error)
+	// Resize changes the size of a server, shutting it down for the change and starting it again afterwards.
+	Resize(ctx context.Context, name string, opts ResizeOpts) error
+	// Rebuild reinstalls the operating system of a server, wiping its disk but keeping its address.
+	Rebuild(ctx context.Context, name string) error
+}
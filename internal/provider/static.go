@@ -0,0 +1,56 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+)
+
+var _ Provider = (*Static)(nil)
+
+// Static is a single pre-existing server reachable at a fixed host, such as a machine on another provider or a
+// local VM. It cannot create or delete servers.
+type Static struct {
+	server Server
+}
+
+func NewStatic(name, host string, port int) *Static {
+	if name == "" {
+		name = host
+	}
+	return &Static{server: Server{
+		ID:     host,
+		Name:   name,
+		Status: StatusRunning,
+		Host:   host,
+		Port:   port,
+	}}
+}
+
+func (p *Static) Resolve(_ context.Context, name string) (*Server, error) {
+	if name != "" && name != p.server.Name {
+		return nil, fmt.Errorf("server %q not found, the static provider only knows %q", name, p.server.Name)
+	}
+	server := p.server
+	return &server, nil
+}
+
+func (p *Static) Create(_ context.Context, _ CreateOpts) (*Server, error) {
+	return nil, fmt.Errorf("create server: %w", ErrNotSupported)
+}
+
+func (p *Static) Delete(_ context.Context, _ string) error {
+	return fmt.Errorf("delete server: %w", ErrNotSupported)
+}
+
+func (p *Static) List(_ context.Context) ([]*Server, error) {
+	server := p.server
+	return []*Server{&server}, nil
+}
+
+func (p *Static) Resize(_ context.Context, _ string, _ ResizeOpts) error {
+	return fmt.Errorf("resize server: %w", ErrNotSupported)
+}
+
+func (p *Static) Rebuild(_ context.Context, _ string) error {
+	return fmt.Errorf("rebuild server: %w", ErrNotSupported)
+}
@@ -1,9 +1,14 @@
 package reconcile
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"errors"
+	"fmt"
 	"os"
 	"os/exec"
+	"strings"
 )
 
 var _ Reconciler = (*AptGet)(nil)
@@ -13,6 +18,42 @@ type AptGet struct {
 	Packages []string
 }
 
+// Plan reports the packages that are not installed and, when upgrading, the packages an upgrade would install or
+// upgrade. The package lists are not updated, so the upgrades are the ones known since the last `apt-get update`.
+func (r *AptGet) Plan(ctx context.Context) ([]Change, error) {
+	changes, err := r.Check(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.Upgrade {
+		cmd := exec.CommandContext(ctx, "apt-get", "-s", "-q", "dist-upgrade")
+		cmd.Env = append(os.Environ(), "DEBIAN_FRONTEND=noninteractive")
+		out, err := cmd.Output()
+		if err != nil {
+			return nil, fmt.Errorf("simulate apt-get dist-upgrade: %w", err)
+		}
+		changes = append(changes, parseAptSimulation(out)...)
+	}
+
+	return changes, nil
+}
+
+// Check reports the packages that are not installed.
+func (r *AptGet) Check(ctx context.Context) ([]Change, error) {
+	var changes []Change
+	for _, pkg := range r.Packages {
+		installed, err := r.isInstalled(ctx, pkg)
+		if err != nil {
+			return nil, err
+		}
+		if !installed {
+			changes = append(changes, Change{Kind: ChangeAdd, Description: fmt.Sprintf("install package %s", pkg)})
+		}
+	}
+	return changes, nil
+}
+
 func (r *AptGet) Reconcile(ctx context.Context) error {
 	if err := r.execAptGet(ctx, "update"); err != nil {
 		return err
@@ -42,3 +83,46 @@ func (r *AptGet) execAptGet(ctx context.Context, args ...string) error {
 	cmd.Stderr = os.Stderr
 	return cmd.Run()
 }
+
+// isInstalled reports whether dpkg has a package installed.
+func (r *AptGet) isInstalled(ctx context.Context, pkg string) (bool, error) {
+	out, err := exec.CommandContext(ctx, "dpkg-query", "-W", "-f=${db:Status-Status}", pkg).Output()
+	var exitErr *exec.ExitError
+	switch {
+	case errors.As(err, &exitErr):
+		// dpkg-query fails for packages it has never heard of
+		return false, nil
+	case err != nil:
+		return false, fmt.Errorf("query package %s: %w", pkg, err)
+	}
+	return strings.TrimSpace(string(out)) == "installed", nil
+}
+
+// parseAptSimulation turns the `Inst` lines of a simulated apt-get run into changes. The lines look like:
+// Inst libc6 [2.39-0ubuntu8.3] (2.39-0ubuntu8.4 Ubuntu:24.04/noble-updates [amd64])
+// Inst linux-image-6.8.0-60-generic (6.8.0-60.63 Ubuntu:24.04/noble-updates [amd64])
+func parseAptSimulation(out []byte) []Change {
+	var changes []Change
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 3 || fields[0] != "Inst" {
+			continue
+		}
+		pkg, rest := fields[1], fields[2:]
+		if strings.HasPrefix(rest[0], "[") && len(rest) > 1 {
+			from := strings.Trim(rest[0], "[]")
+			to := strings.TrimPrefix(rest[1], "(")
+			changes = append(changes, Change{
+				Kind:        ChangeUpdate,
+				Description: fmt.Sprintf("upgrade package %s from %s to %s", pkg, from, to),
+			})
+			continue
+		}
+		changes = append(changes, Change{
+			Kind:        ChangeAdd,
+			Description: fmt.Sprintf("install package %s %s", pkg, strings.TrimPrefix(rest[0], "(")),
+		})
+	}
+	return changes
+}
@@ -0,0 +1,46 @@
+package reconcile
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseAptSimulation(t *testing.T) {
+	tests := []struct {
+		name string
+		out  string
+		want []Change
+	}{
+		{name: "nothing to do", out: "Reading package lists...\n0 upgraded, 0 newly installed, 0 to remove\n"},
+		{
+			name: "upgrade",
+			out:  "Inst libc6 [2.39-0ubuntu8.3] (2.39-0ubuntu8.4 Ubuntu:24.04/noble-updates [amd64])\n",
+			want: []Change{{Kind: ChangeUpdate, Description: "upgrade package libc6 from 2.39-0ubuntu8.3 to 2.39-0ubuntu8.4"}},
+		},
+		{
+			name: "install",
+			out:  "Inst linux-image-6.8.0-60-generic (6.8.0-60.63 Ubuntu:24.04/noble-updates [amd64])\n",
+			want: []Change{{Kind: ChangeAdd, Description: "install package linux-image-6.8.0-60-generic 6.8.0-60.63"}},
+		},
+		{
+			name: "mixed with other lines",
+			out: "NOTE: This is only a simulation!\n" +
+				"Inst jq (1.7.1-3build1 Ubuntu:24.04/noble [amd64])\n" +
+				"Conf jq (1.7.1-3build1 Ubuntu:24.04/noble [amd64])\n" +
+				"Inst curl [8.5.0-2ubuntu10.5] (8.5.0-2ubuntu10.6 Ubuntu:24.04/noble-updates [amd64]) []\n" +
+				"Remv oldpkg [1.0]\n",
+			want: []Change{
+				{Kind: ChangeAdd, Description: "install package jq 1.7.1-3build1"},
+				{Kind: ChangeUpdate, Description: "upgrade package curl from 8.5.0-2ubuntu10.5 to 8.5.0-2ubuntu10.6"},
+			},
+		},
+		{name: "truncated line", out: "Inst jq\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseAptSimulation([]byte(tt.out)); !slices.Equal(got, tt.want) {
+				t.Errorf("parseAptSimulation() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
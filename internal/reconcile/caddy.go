@@ -3,6 +3,7 @@ package reconcile
 import (
 	"context"
 	_ "embed"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
@@ -20,6 +21,78 @@ var _ Reconciler = (*Caddy)(nil)
 
 type Caddy struct{}
 
+var caddyImageRegexp = regexp.MustCompile(`(?m)^\s*image:\s*caddy:(\S+)\s*$`)
+
+// Plan reports whether Reconcile would install Caddy, change its version or configuration, or start it.
+func (r *Caddy) Plan(ctx context.Context) ([]Change, error) {
+	latest, err := r.latestVersion(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return r.diff(ctx, latest)
+}
+
+// Check reports whether Caddy is missing, has a changed configuration, or is not running the version it was set up
+// with. Newer versions are not looked up.
+func (r *Caddy) Check(ctx context.Context) ([]Change, error) {
+	return r.diff(ctx, "")
+}
+
+// diff compares the installation against the embedded configuration and, when given, the latest version.
+func (r *Caddy) diff(ctx context.Context, latest string) ([]Change, error) {
+	compose, err := os.ReadFile("/root/.caddy/compose.yml")
+	if errors.Is(err, os.ErrNotExist) {
+		return []Change{{Kind: ChangeAdd, Description: strings.TrimSpace("install Caddy " + latest)}}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []Change
+	current := "unknown"
+	if m := caddyImageRegexp.FindSubmatch(compose); m != nil {
+		current = string(m[1])
+	}
+	if latest != "" && current != latest {
+		changes = append(changes, Change{
+			Kind:        ChangeUpdate,
+			Description: fmt.Sprintf("update Caddy from %s to %s", current, latest),
+		})
+	}
+	caddyfile, err := os.ReadFile("/root/.caddy/Caddyfile")
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if string(caddyfile) != caddyCaddyfileFile {
+		changes = append(changes, Change{Kind: ChangeUpdate, Description: "update /root/.caddy/Caddyfile"})
+	}
+
+	// Make sure the container runs the image in compose.yml, which differs when it was changed by hand
+	cmd := exec.CommandContext(ctx, "docker", "compose", "ps", "--status", "running",
+		"--format", "{{.Service}} {{.Image}}")
+	cmd.Dir = "/root/.caddy"
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("docker compose ps for Caddy: %w", err)
+	}
+	image := ""
+	for line := range strings.SplitSeq(string(out), "\n") {
+		if fields := strings.Fields(line); len(fields) == 2 && fields[0] == "caddy" {
+			image = fields[1]
+		}
+	}
+	switch {
+	case image == "":
+		changes = append(changes, Change{Kind: ChangeAdd, Description: "start Caddy"})
+	case current != "unknown" && image != "caddy:"+current:
+		changes = append(changes, Change{
+			Kind:        ChangeUpdate,
+			Description: fmt.Sprintf("recreate Caddy with caddy:%s, it runs %s", current, image),
+		})
+	}
+	return changes, nil
+}
+
 func (r *Caddy) Reconcile(ctx context.Context) error {
 	// Create the necessary directories
 	for _, c := range []struct {
@@ -37,27 +110,17 @@ func (r *Caddy) Reconcile(ctx context.Context) error {
 	}
 
 	// Figure out the latest Caddy version
-	const caddyMajorVersion = "2"
-	caddyVersion, err := r.execCapture(ctx, "bash", "-lc",
-		`curl -s 'https://hub.docker.com/v2/repositories/library/caddy/tags?page_size=100&ordering=last_updated'`+` | `+
-			fmt.Sprintf(`jq -r '.results[].name | select(test("^%s\\.[0-9]+\\.[0-9]+$"))'`, caddyMajorVersion)+` | `+
-			`head -n1`,
-	)
+	caddyVersion, err := r.latestVersion(ctx)
 	if err != nil {
 		return err
 	}
-	caddyVersion = []byte(strings.TrimSpace(string(caddyVersion)))
-	caddyVersionRegexp := regexp.MustCompile(`^` + caddyMajorVersion + `\.[0-9]+\.[0-9]+$`)
-	if !caddyVersionRegexp.MatchString(string(caddyVersion)) {
-		return fmt.Errorf("Failed to determine latest Caddy version, got: %q", string(caddyVersion))
-	}
-	fmt.Printf("Using Caddy version: %s\n", string(caddyVersion))
+	fmt.Printf("Using Caddy version: %s\n", caddyVersion)
 
 	// Create the Caddyfile and Docker Compose file
 	if err := os.WriteFile("/root/.caddy/Caddyfile", []byte(caddyCaddyfileFile), 0644); err != nil {
 		return err
 	}
-	composeContents := strings.ReplaceAll(caddyComposeFile, "{{VERSION}}", string(caddyVersion))
+	composeContents := strings.ReplaceAll(caddyComposeFile, "{{VERSION}}", caddyVersion)
 	if err := os.WriteFile("/root/.caddy/compose.yml", []byte(composeContents), 0644); err != nil {
 		return err
 	}
@@ -80,6 +143,25 @@ func (r *Caddy) Reconcile(ctx context.Context) error {
 	return nil
 }
 
+// latestVersion looks up the latest Caddy version of the supported major version on Docker Hub.
+func (r *Caddy) latestVersion(ctx context.Context) (string, error) {
+	const caddyMajorVersion = "2"
+	caddyVersion, err := r.execCapture(ctx, "bash", "-lc",
+		`curl -s 'https://hub.docker.com/v2/repositories/library/caddy/tags?page_size=100&ordering=last_updated'`+` | `+
+			fmt.Sprintf(`jq -r '.results[].name | select(test("^%s\\.[0-9]+\\.[0-9]+$"))'`, caddyMajorVersion)+` | `+
+			`head -n1`,
+	)
+	if err != nil {
+		return "", err
+	}
+	caddyVersion = []byte(strings.TrimSpace(string(caddyVersion)))
+	caddyVersionRegexp := regexp.MustCompile(`^` + caddyMajorVersion + `\.[0-9]+\.[0-9]+$`)
+	if !caddyVersionRegexp.MatchString(string(caddyVersion)) {
+		return "", fmt.Errorf("Failed to determine latest Caddy version, got: %q", string(caddyVersion))
+	}
+	return string(caddyVersion), nil
+}
+
 func (r *Caddy) execCapture(ctx context.Context, name string, args ...string) ([]byte, error) {
 	cmd := exec.CommandContext(ctx, name, args...)
 	return cmd.Output()
@@ -2,6 +2,9 @@ package reconcile
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"os"
 	"os/exec"
 	"strings"
@@ -10,14 +13,132 @@ import (
 var _ Reconciler = (*Node)(nil)
 
 type Node struct {
+	// Version is the Node.js version to install with nvm, where "lts" or an empty version is the latest LTS release.
+	Version        string
 	GlobalPackages []string
 }
 
+const nvmSource = `export NVM_DIR="$HOME/.nvm"; source "$NVM_DIR/nvm.sh"; `
+
+// Plan reports, for both root and the deploy user, whether Reconcile would install nvm, a newer Node.js release, or
+// missing or outdated global npm packages.
+func (r *Node) Plan(ctx context.Context) ([]Change, error) {
+	return r.diff(ctx, true)
+}
+
+// Check reports, for both root and the deploy user, whether nvm, a Node.js release matching the version, or any of the
+// global npm packages are missing. Newer releases are not looked up.
+func (r *Node) Check(ctx context.Context) ([]Change, error) {
+	return r.diff(ctx, false)
+}
+
+// diff compares the installations of both users against the reconciler, comparing against the latest releases only
+// when asked to.
+func (r *Node) diff(ctx context.Context, latest bool) ([]Change, error) {
+	var changes []Change
+	for _, user := range []string{"root", "deploy"} {
+		if _, err := r.execCaptureAs(ctx, user, `test -s "$HOME/.nvm/nvm.sh"`); err != nil {
+			changes = append(changes, Change{
+				Kind:        ChangeAdd,
+				Description: fmt.Sprintf("install nvm, Node.js %s and npm packages for %s", r.nvmVersion(), user),
+			})
+			continue
+		}
+
+		// Compare the newest installed release matching the version against the latest one; nvm reports N/A and
+		// fails when there is none
+		var exitErr *exec.ExitError
+		out, err := r.execCaptureAs(ctx, user, nvmSource+fmt.Sprintf(`nvm version '%s'`, r.nvmVersion()))
+		if err != nil && !errors.As(err, &exitErr) {
+			return nil, fmt.Errorf("get installed Node.js version for %s: %w", user, err)
+		}
+		installed := strings.TrimSpace(string(out))
+		if installed == "" {
+			installed = "N/A"
+		}
+		if latest {
+			out, err := r.execCaptureAs(ctx, user, nvmSource+fmt.Sprintf(`nvm version-remote '%s'`, r.nvmVersion()))
+			if err != nil {
+				return nil, fmt.Errorf("get latest Node.js version for %s: %w", user, err)
+			}
+			if want := strings.TrimSpace(string(out)); installed != want {
+				changes = append(changes, Change{
+					Kind:        ChangeUpdate,
+					Description: fmt.Sprintf("install Node.js %s for %s (installed: %s)", want, user, installed),
+				})
+			}
+		} else if installed == "N/A" {
+			changes = append(changes, Change{
+				Kind:        ChangeAdd,
+				Description: fmt.Sprintf("install Node.js %s for %s", r.nvmVersion(), user),
+			})
+		}
+		if installed == "N/A" {
+			// Without Node.js there is no npm to ask, and every global package is still to be installed
+			changes = append(changes, r.packageChanges(user, nil, nil)...)
+			continue
+		}
+
+		// Compare the global packages against the installed and outdated ones; npm outdated exits with 1 when some are
+		var listed struct {
+			Dependencies map[string]any `json:"dependencies"`
+		}
+		out, err = r.execCaptureAs(ctx, user, nvmSource+`npm ls -g --depth=0 --json`)
+		if err != nil {
+			return nil, fmt.Errorf("list global npm packages for %s: %w", user, err)
+		}
+		if err := json.Unmarshal(out, &listed); err != nil {
+			return nil, fmt.Errorf("parse global npm packages for %s: %w", user, err)
+		}
+		var outdated map[string]npmOutdated
+		if latest {
+			out, err = r.execCaptureAs(ctx, user, nvmSource+`npm outdated -g --json`)
+			if err != nil && !errors.As(err, &exitErr) {
+				return nil, fmt.Errorf("list outdated global npm packages for %s: %w", user, err)
+			}
+			if len(strings.TrimSpace(string(out))) > 0 {
+				if err := json.Unmarshal(out, &outdated); err != nil {
+					return nil, fmt.Errorf("parse outdated global npm packages for %s: %w", user, err)
+				}
+			}
+		}
+		changes = append(changes, r.packageChanges(user, listed.Dependencies, outdated)...)
+	}
+	return changes, nil
+}
+
+// npmOutdated is an entry of `npm outdated --json`.
+type npmOutdated struct {
+	Current string `json:"current"`
+	Latest  string `json:"latest"`
+}
+
+// packageChanges compares the global packages against the installed and outdated ones of a user.
+func (r *Node) packageChanges(user string, installed map[string]any, outdated map[string]npmOutdated) []Change {
+	var changes []Change
+	for _, pkg := range r.GlobalPackages {
+		name := npmPackageName(pkg)
+		if _, ok := installed[name]; !ok {
+			changes = append(changes, Change{
+				Kind:        ChangeAdd,
+				Description: fmt.Sprintf("install npm package %s for %s", name, user),
+			})
+		} else if o, ok := outdated[name]; ok {
+			changes = append(changes, Change{
+				Kind:        ChangeUpdate,
+				Description: fmt.Sprintf("update npm package %s for %s from %s to %s", name, user, o.Current, o.Latest),
+			})
+		}
+	}
+	return changes
+}
+
 func (r *Node) Reconcile(ctx context.Context) error {
 	// Based on the official instructions at: https://nodejs.org/en/download
 	cmds := []string{
 		`curl -o- https://raw.githubusercontent.com/nvm-sh/nvm/v0.40.3/install.sh | bash`,
-		`export NVM_DIR="$HOME/.nvm"; source "$NVM_DIR/nvm.sh"; nvm install --lts`,
+		fmt.Sprintf(`export NVM_DIR="$HOME/.nvm"; source "$NVM_DIR/nvm.sh"; nvm install '%[1]s' && nvm alias default '%[1]s'`,
+			r.nvmVersion()),
 		`export NVM_DIR="$HOME/.nvm"; source "$NVM_DIR/nvm.sh"; node -v && npm -v`,
 	}
 	for _, cmd := range cmds {
@@ -29,7 +150,10 @@ func (r *Node) Reconcile(ctx context.Context) error {
 		}
 	}
 
-	// Install global npm packages for both root and deploy user
+	// Install global npm packages for both root and deploy user; without packages, npm would install the working directory
+	if len(r.GlobalPackages) == 0 {
+		return nil
+	}
 	npmInstallCmd := `export NVM_DIR="$HOME/.nvm"; source "$NVM_DIR/nvm.sh"; npm install -g ` +
 		strings.Join(r.GlobalPackages, " ")
 	if err := r.execRun(ctx, "bash", "-lc", npmInstallCmd); err != nil {
@@ -48,3 +172,27 @@ func (r *Node) execRun(ctx context.Context, name string, args ...string) error {
 	cmd.Stderr = os.Stderr
 	return cmd.Run()
 }
+
+// nvmVersion returns the version to pass to nvm, which calls the latest LTS release `lts/*`.
+func (r *Node) nvmVersion() string {
+	if r.Version == "" || r.Version == "lts" {
+		return "lts/*"
+	}
+	return r.Version
+}
+
+// execCaptureAs runs a Bash login script as root or as another user and returns its output.
+func (r *Node) execCaptureAs(ctx context.Context, user, script string) ([]byte, error) {
+	if user == "root" {
+		return exec.CommandContext(ctx, "bash", "-lc", script).Output()
+	}
+	return exec.CommandContext(ctx, "sudo", "-u", user, "bash", "-lc", script).Output()
+}
+
+// npmPackageName strips the version from a package spec, e.g. `@openai/codex@latest` becomes `@openai/codex`.
+func npmPackageName(spec string) string {
+	if i := strings.LastIndex(spec, "@"); i > 0 {
+		return spec[:i]
+	}
+	return spec
+}
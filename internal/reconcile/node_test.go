@@ -0,0 +1,45 @@
+package reconcile
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestNodePackageChanges(t *testing.T) {
+	r := &Node{GlobalPackages: []string{"npm@latest", "@openai/codex@latest", "pnpm"}}
+	tests := []struct {
+		name      string
+		installed map[string]any
+		outdated  map[string]npmOutdated
+		want      []Change
+	}{
+		{
+			name: "no Node.js installed",
+			want: []Change{
+				{Kind: ChangeAdd, Description: "install npm package npm for deploy"},
+				{Kind: ChangeAdd, Description: "install npm package @openai/codex for deploy"},
+				{Kind: ChangeAdd, Description: "install npm package pnpm for deploy"},
+			},
+		},
+		{
+			name:      "all installed",
+			installed: map[string]any{"npm": nil, "@openai/codex": nil, "pnpm": nil, "corepack": nil},
+		},
+		{
+			name:      "missing and outdated",
+			installed: map[string]any{"npm": nil, "@openai/codex": nil},
+			outdated:  map[string]npmOutdated{"npm": {Current: "10.8.2", Latest: "11.0.0"}, "corepack": {}},
+			want: []Change{
+				{Kind: ChangeUpdate, Description: "update npm package npm for deploy from 10.8.2 to 11.0.0"},
+				{Kind: ChangeAdd, Description: "install npm package pnpm for deploy"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := r.packageChanges("deploy", tt.installed, tt.outdated); !slices.Equal(got, tt.want) {
+				t.Errorf("packageChanges() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
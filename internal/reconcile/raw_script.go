@@ -2,14 +2,44 @@ package reconcile
 
 import (
 	"context"
+	"fmt"
 	"os"
 	"os/exec"
+	"strings"
 )
 
 var _ Reconciler = (*RawScript)(nil)
 
 type RawScript struct {
 	Script string
+	// CheckScript prints a line for every way the machine differs from what Script sets up. Without it, the script is
+	// not checked.
+	CheckScript string
+}
+
+// Plan reports the script as a change, as there is no telling what it changes without running it.
+func (r *RawScript) Plan(ctx context.Context) ([]Change, error) {
+	return []Change{{Kind: ChangeRun, Description: "run script"}}, nil
+}
+
+// Check runs the check script, reporting every line it prints as drift.
+func (r *RawScript) Check(ctx context.Context) ([]Change, error) {
+	if r.CheckScript == "" {
+		return nil, nil
+	}
+	cmd := exec.CommandContext(ctx, "bash", "-euo", "pipefail", "-c", r.CheckScript)
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("run check script: %w", err)
+	}
+	var changes []Change
+	for line := range strings.SplitSeq(string(out), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			changes = append(changes, Change{Kind: ChangeUpdate, Description: line})
+		}
+	}
+	return changes, nil
 }
 
 func (r *RawScript) Reconcile(ctx context.Context) error {
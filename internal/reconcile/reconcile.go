@@ -3,5 +3,30 @@ package reconcile
 import "context"
 
 type Reconciler interface {
+	// Plan reports the changes Reconcile would make, without changing anything.
+	Plan(ctx context.Context) ([]Change, error)
+	// Check reports where the machine has drifted from what Reconcile sets up, without changing anything. Unlike Plan,
+	// it does not count updates released since the last run as drift.
+	Check(ctx context.Context) ([]Change, error)
 	Reconcile(ctx context.Context) error
 }
+
+type ChangeKind string
+
+const (
+	ChangeAdd    ChangeKind = "+"
+	ChangeRemove ChangeKind = "-"
+	ChangeUpdate ChangeKind = "~"
+	// ChangeRun is a script whose effect cannot be known without running it.
+	ChangeRun ChangeKind = "!"
+)
+
+// Change is a single change a reconciler would make.
+type Change struct {
+	Kind        ChangeKind
+	Description string
+}
+
+func (c Change) String() string {
+	return string(c.Kind) + " " + c.Description
+}
@@ -18,6 +18,55 @@ type Ufw struct {
 	AllowedTcpPorts []int
 }
 
+// Plan reports the rules Reconcile would delete and add, and whether it would enable ufw.
+func (r *Ufw) Plan(ctx context.Context) ([]Change, error) {
+	if _, err := exec.LookPath("ufw"); err != nil {
+		return []Change{{Kind: ChangeAdd, Description: "install ufw, enable it and allow the desired ports"}}, nil
+	}
+
+	desiredPorts := uniqueInts(r.AllowedTcpPorts)
+	sort.Ints(desiredPorts)
+
+	// An inactive ufw does not list its rules, so enabling it is planned with every desired port
+	active, err := r.isActive(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("check ufw status: %w", err)
+	}
+	if !active {
+		changes := []Change{{Kind: ChangeAdd, Description: "enable ufw"}}
+		for _, p := range desiredPorts {
+			changes = append(changes, Change{Kind: ChangeAdd, Description: fmt.Sprintf("allow %d/tcp", p)})
+		}
+		return changes, nil
+	}
+
+	toAdd, toDelete, err := r.diff(ctx, desiredPorts)
+	if err != nil {
+		return nil, err
+	}
+	var changes []Change
+	for _, rule := range toDelete {
+		rest := strings.Fields(rule.Action + " " + rule.Direction + " " + rule.From)
+		changes = append(changes, Change{
+			Kind:        ChangeRemove,
+			Description: fmt.Sprintf("delete rule [%d] %s %s", rule.Number, rule.To, strings.Join(rest, " ")),
+		})
+	}
+	for _, k := range toAdd {
+		spec := fmt.Sprintf("%d/tcp", k.Port)
+		if k.V6 {
+			spec += " (v6)"
+		}
+		changes = append(changes, Change{Kind: ChangeAdd, Description: "allow " + spec})
+	}
+	return changes, nil
+}
+
+// Check reports the same differences as Plan, as the rules have to match the desired ports exactly.
+func (r *Ufw) Check(ctx context.Context) ([]Change, error) {
+	return r.Plan(ctx)
+}
+
 func (r *Ufw) Reconcile(ctx context.Context) error {
 	if _, err := exec.LookPath("ufw"); err != nil {
 		return fmt.Errorf("ufw not found: %w", err)
@@ -48,28 +97,59 @@ func (r *Ufw) Reconcile(ctx context.Context) error {
 		}
 	}
 
+	toAdd, toDelete, err := r.diff(ctx, desiredPorts)
+	if err != nil {
+		return err
+	}
+
+	// Apply deletions
+	for _, rule := range toDelete {
+		if err := r.execRun(ctx, "ufw", "--force", "delete", strconv.Itoa(rule.Number)); err != nil {
+			return fmt.Errorf("ufw delete rule %d: %w", rule.Number, err)
+		}
+	}
+
+	// Apply additions
+	for _, k := range toAdd {
+		spec := fmt.Sprintf("%d/tcp", k.Port)
+		if err := r.execRun(ctx, "ufw", "allow", spec); err != nil {
+			return fmt.Errorf("ufw allow %s: %w", spec, err)
+		}
+	}
+
+	if err := r.execRun(ctx, "ufw", "status", "verbose"); err != nil {
+		return fmt.Errorf("ufw status verbose: %w", err)
+	}
+	return nil
+}
+
+type ufwKey struct {
+	Port int
+	V6   bool
+}
+
+// diff compares the current rules of an active ufw against the desired ports. It returns the allows to add, for
+// both IPv4 and IPv6, and the rules to delete, ordered from the highest rule number to the lowest so that deleting
+// them in order keeps the numbering stable.
+func (r *Ufw) diff(ctx context.Context, desiredPorts []int) ([]ufwKey, []ufwRule, error) {
 	// Get current rules, numbered, to allow deletes by number.
 	out, err := r.execCapture(ctx, "ufw", "status", "numbered")
 	if err != nil {
-		return fmt.Errorf("ufw status: %w", err)
+		return nil, nil, fmt.Errorf("ufw status: %w", err)
 	}
 	current := parseUfwStatusNumbered(out)
 
 	// Compute desired set: for each port, want both v4 and v6 allows (tcp).
-	type key struct {
-		Port int
-		V6   bool
-	}
-	desired := make(map[key]struct{}, len(desiredPorts)*2)
+	desired := make(map[ufwKey]struct{}, len(desiredPorts)*2)
 	for _, p := range desiredPorts {
-		desired[key{Port: p, V6: false}] = struct{}{}
-		desired[key{Port: p, V6: true}] = struct{}{}
+		desired[ufwKey{Port: p, V6: false}] = struct{}{}
+		desired[ufwKey{Port: p, V6: true}] = struct{}{}
 	}
 
 	// Determine adds and deletes.
-	// Track present TCP allows (both v4/v6) and their rule numbers.
-	currentAllows := make(map[key][]int)
-	var toDelete []int
+	// Track present TCP allows (both v4/v6) and their rules.
+	currentAllows := make(map[ufwKey][]ufwRule)
+	var toDelete []ufwRule
 	for _, rule := range current {
 		if !strings.EqualFold(rule.Action, "allow") {
 			continue
@@ -79,52 +159,42 @@ func (r *Ufw) Reconcile(ctx context.Context) error {
 		}
 
 		if rule.Protocol == "tcp" && !rule.Range && rule.Service == "" && rule.Port > 0 {
-			k := key{Port: rule.Port, V6: rule.V6}
+			k := ufwKey{Port: rule.Port, V6: rule.V6}
 			if _, ok := desired[k]; ok {
-				currentAllows[k] = append(currentAllows[k], rule.Number)
+				currentAllows[k] = append(currentAllows[k], rule)
 				continue
 			}
 		}
 
-		toDelete = append(toDelete, rule.Number)
+		toDelete = append(toDelete, rule)
 	}
 
 	// Adds: any desired not present
-	var toAdd []key
+	var toAdd []ufwKey
 	for k := range desired {
 		if _, ok := currentAllows[k]; !ok {
 			toAdd = append(toAdd, k)
 		}
 	}
+	sort.Slice(toAdd, func(i, j int) bool {
+		if toAdd[i].Port != toAdd[j].Port {
+			return toAdd[i].Port < toAdd[j].Port
+		}
+		return !toAdd[i].V6 && toAdd[j].V6
+	})
 
 	// Deletes: drop duplicate rules for ports we manage.
-	for _, nums := range currentAllows {
-		if len(nums) > 1 {
-			toDelete = append(toDelete, nums[1:]...)
+	for _, rules := range currentAllows {
+		if len(rules) > 1 {
+			toDelete = append(toDelete, rules[1:]...)
 		}
 	}
 	// Delete from highest to lowest to keep numbering stable
-	sort.Sort(sort.Reverse(sort.IntSlice(toDelete)))
+	sort.Slice(toDelete, func(i, j int) bool {
+		return toDelete[i].Number > toDelete[j].Number
+	})
 
-	// Apply deletions
-	for _, n := range toDelete {
-		if err := r.execRun(ctx, "ufw", "--force", "delete", strconv.Itoa(n)); err != nil {
-			return fmt.Errorf("ufw delete rule %d: %w", n, err)
-		}
-	}
-
-	// Apply additions
-	for _, k := range toAdd {
-		spec := fmt.Sprintf("%d/tcp", k.Port)
-		if err := r.execRun(ctx, "ufw", "allow", spec); err != nil {
-			return fmt.Errorf("ufw allow %s: %w", spec, err)
-		}
-	}
-
-	if err := r.execRun(ctx, "ufw", "status", "verbose"); err != nil {
-		return fmt.Errorf("ufw status verbose: %w", err)
-	}
-	return nil
+	return toAdd, toDelete, nil
 }
 
 func (r *Ufw) execCapture(ctx context.Context, name string, args ...string) ([]byte, error) {
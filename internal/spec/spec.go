@@ -0,0 +1,127 @@
+package spec
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// DefaultPath is where the machine spec is looked up relative to the project root.
+const DefaultPath = ".ship/machine.toml"
+
+var (
+	aptPackageRegexp  = regexp.MustCompile(`^[a-z0-9][a-z0-9+.-]+$`)
+	snapPackageRegexp = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+	npmPackageRegexp  = regexp.MustCompile(`^(@[a-z0-9._-]+/)?[a-z0-9._-]+(@[A-Za-z0-9._^~<>=-]+)?$`)
+	nodeVersionRegexp = regexp.MustCompile(`^(lts|[0-9]+(\.[0-9]+){0,2})$`)
+)
+
+// Machine selects and configures what `machine up` installs on a machine. The packages, ports and services ship
+// itself depends on are always set up; the spec only adds to them. Keys left out of a spec file keep the values of the
+// default profile, so an empty file describes the default machine.
+type Machine struct {
+	Apt  Apt  `toml:"apt"`
+	Snap Snap `toml:"snap"`
+	Ufw  Ufw  `toml:"ufw"`
+	Node Node `toml:"node"`
+}
+
+type Apt struct {
+	// Packages are installed with apt-get in addition to the packages ship needs.
+	Packages []string `toml:"packages"`
+}
+
+type Snap struct {
+	// Packages are installed with snap, which is only set up when there are any.
+	Packages []string `toml:"packages"`
+}
+
+type Ufw struct {
+	// AllowedTCPPorts are allowed through the firewall in addition to the SSH, HTTP and HTTPS ports.
+	AllowedTCPPorts []int `toml:"allowed_tcp_ports"`
+}
+
+// Node configures Node.js, which is installed with nvm for both root and the `deploy` user. The version is either
+// "lts" for the latest LTS release or a version number such as "22".
+type Node struct {
+	Enabled        bool     `toml:"enabled"`
+	Version        string   `toml:"version"`
+	GlobalPackages []string `toml:"global_packages"`
+}
+
+// Default returns the default profile, which is used for machines without a spec.
+func Default() *Machine {
+	return &Machine{
+		Apt: Apt{
+			Packages: []string{"ripgrep", "tree"},
+		},
+		Snap: Snap{
+			Packages: []string{"btop", "dust"},
+		},
+		Ufw: Ufw{
+			AllowedTCPPorts: []int{},
+		},
+		Node: Node{
+			Enabled: true,
+			Version: "lts",
+			GlobalPackages: []string{
+				"npm@latest",
+				"@openai/codex@latest",
+				"@anthropic-ai/claude-code@latest",
+			},
+		},
+	}
+}
+
+// Parse decodes a spec on top of the default profile and validates it.
+func Parse(data []byte) (*Machine, error) {
+	m := Default()
+	meta, err := toml.NewDecoder(bytes.NewReader(data)).Decode(m)
+	if err != nil {
+		return nil, fmt.Errorf("decode: %w", err)
+	}
+	if undecoded := meta.Undecoded(); len(undecoded) > 0 {
+		keys := make([]string, 0, len(undecoded))
+		for _, k := range undecoded {
+			keys = append(keys, k.String())
+		}
+		return nil, fmt.Errorf("unknown keys: %s", strings.Join(keys, ", "))
+	}
+	if err := m.Validate(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Validate checks the spec, including that every name is safe to pass to a shell.
+func (m *Machine) Validate() error {
+	for _, p := range m.Apt.Packages {
+		if !aptPackageRegexp.MatchString(p) {
+			return fmt.Errorf("apt package %q is not a valid package name", p)
+		}
+	}
+	for _, p := range m.Snap.Packages {
+		if !snapPackageRegexp.MatchString(p) {
+			return fmt.Errorf("snap package %q is not a valid package name", p)
+		}
+	}
+	for _, p := range m.Ufw.AllowedTCPPorts {
+		if p < 1 || p > 65535 {
+			return fmt.Errorf("ufw port %d must be between 1 and 65535", p)
+		}
+	}
+	if m.Node.Enabled {
+		if !nodeVersionRegexp.MatchString(m.Node.Version) {
+			return fmt.Errorf("node version %q must be \"lts\" or a version number such as \"22\"", m.Node.Version)
+		}
+		for _, p := range m.Node.GlobalPackages {
+			if !npmPackageRegexp.MatchString(p) {
+				return fmt.Errorf("npm package %q is not a valid package spec", p)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package spec
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    func(m *Machine)
+		wantErr string
+	}{
+		{name: "empty spec is the default", data: ""},
+		{
+			name: "keys replace the defaults",
+			data: "[apt]\npackages = [\"htop\", \"libssl3t64\", \"g++\"]\n[ufw]\nallowed_tcp_ports = [8080]\n",
+			want: func(m *Machine) {
+				m.Apt.Packages = []string{"htop", "libssl3t64", "g++"}
+				m.Ufw.AllowedTCPPorts = []int{8080}
+			},
+		},
+		{
+			name: "node settings",
+			data: "[node]\nversion = \"22.11\"\nglobal_packages = [\"pnpm@9\", \"@scope/tool\"]\n",
+			want: func(m *Machine) {
+				m.Node.Version = "22.11"
+				m.Node.GlobalPackages = []string{"pnpm@9", "@scope/tool"}
+			},
+		},
+		{
+			name: "no snap packages",
+			data: "[snap]\npackages = []\n",
+			want: func(m *Machine) { m.Snap.Packages = []string{} },
+		},
+		{
+			name: "disabled node is not validated",
+			data: "[node]\nenabled = false\nversion = \"latest\"\n",
+			want: func(m *Machine) {
+				m.Node.Enabled = false
+				m.Node.Version = "latest"
+			},
+		},
+		{name: "invalid toml", data: "[apt\n", wantErr: "decode"},
+		{name: "wrong type", data: "[ufw]\nallowed_tcp_ports = [\"22\"]\n", wantErr: "decode"},
+		{name: "unknown key", data: "[apt]\npackges = [\"htop\"]\n", wantErr: "unknown keys: apt.packges"},
+		{name: "unknown table", data: "[docker]\nenabled = true\n", wantErr: "unknown keys: docker"},
+		{name: "shell in apt package", data: "[apt]\npackages = [\"htop; rm -rf /\"]\n", wantErr: "apt package"},
+		{name: "uppercase apt package", data: "[apt]\npackages = [\"Htop\"]\n", wantErr: "apt package"},
+		{name: "invalid snap package", data: "[snap]\npackages = [\"a.b\"]\n", wantErr: "snap package"},
+		{name: "port zero", data: "[ufw]\nallowed_tcp_ports = [0]\n", wantErr: "between 1 and 65535"},
+		{name: "port too large", data: "[ufw]\nallowed_tcp_ports = [65536]\n", wantErr: "between 1 and 65535"},
+		{name: "invalid node version", data: "[node]\nversion = \"latest\"\n", wantErr: "node version"},
+		{name: "invalid npm package", data: "[node]\nglobal_packages = [\"$(id)\"]\n", wantErr: "npm package"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse([]byte(tt.data))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Parse() error = %v, want error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			want := Default()
+			if tt.want != nil {
+				tt.want(want)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("Parse() = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
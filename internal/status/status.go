@@ -0,0 +1,44 @@
+package status
+
+import "time"
+
+// Machine is the overview of a machine that the agent reports as JSON and the client renders.
+type Machine struct {
+	Hostname       string   `json:"hostname"`
+	RootAgents     []string `json:"root_agents"`
+	DeployAgents   []string `json:"deploy_agents"`
+	Apps           []App    `json:"apps"`
+	Caddy          Caddy    `json:"caddy"`
+	Disk           Usage    `json:"disk"`
+	Memory         Usage    `json:"memory"`
+	Upgrades       int      `json:"upgrades"`
+	RebootRequired bool     `json:"reboot_required"`
+	// Warnings lists the parts of the overview that could not be determined.
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+type App struct {
+	Name       string    `json:"name"`
+	Release    string    `json:"release"`
+	DeployedAt time.Time `json:"deployed_at"`
+	Project    string    `json:"project,omitempty"`
+	Services   []Service `json:"services"`
+}
+
+type Service struct {
+	Name     string `json:"name"`
+	State    string `json:"state"`
+	Health   string `json:"health,omitempty"`
+	Restarts int    `json:"restarts"`
+}
+
+type Caddy struct {
+	State string   `json:"state"`
+	Sites []string `json:"sites"`
+}
+
+// Usage is the used and total size of a resource in bytes.
+type Usage struct {
+	Used  uint64 `json:"used"`
+	Total uint64 `json:"total"`
+}